@@ -0,0 +1,55 @@
+package main
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+// Deprecated returns the sorted names of the kept declarations whose doc
+// comment has a paragraph starting with "Deprecated:", with methods
+// qualified by their receiver type as "T.M".
+func (r *Result) Deprecated() []string {
+	var names []string
+	for _, decl := range r.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if isDeprecated(d.Doc) {
+				names = append(names, declNames([]ast.Decl{d})...)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				doc := d.Doc
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Doc != nil {
+						doc = s.Doc
+					}
+				case *ast.ValueSpec:
+					if s.Doc != nil {
+						doc = s.Doc
+					}
+				}
+				if isDeprecated(doc) {
+					names = append(names, declNames([]ast.Decl{&ast.GenDecl{Tok: d.Tok, Specs: []ast.Spec{spec}}})...)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isDeprecated reports whether doc has a paragraph starting with
+// "Deprecated:", the convention recognized by go doc and linters.
+func isDeprecated(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, para := range strings.Split(doc.Text(), "\n\n") {
+		if strings.HasPrefix(para, "Deprecated:") {
+			return true
+		}
+	}
+	return false
+}
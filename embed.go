@@ -0,0 +1,101 @@
+package main
+
+import (
+	"go/ast"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// embedPatterns returns the patterns of the //go:embed directives in doc.
+func embedPatterns(doc *ast.CommentGroup) []string {
+	if doc == nil {
+		return nil
+	}
+	var patterns []string
+	for _, c := range doc.List {
+		args, ok := strings.CutPrefix(c.Text, "//go:embed ")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(args) {
+			if unquoted, err := strconv.Unquote(field); err == nil {
+				field = unquoted
+			}
+			patterns = append(patterns, field)
+		}
+	}
+	return patterns
+}
+
+// declEmbeds returns the patterns of the //go:embed directives of the specs
+// of decl, a var declaration.
+func declEmbeds(decl ast.Decl) []string {
+	gd, ok := decl.(*ast.GenDecl)
+	if !ok {
+		return nil
+	}
+	patterns := embedPatterns(gd.Doc)
+	for _, spec := range gd.Specs {
+		if vs, ok := spec.(*ast.ValueSpec); ok {
+			patterns = append(patterns, embedPatterns(vs.Doc)...)
+		}
+	}
+	return patterns
+}
+
+// CopyEmbeds copies the files embedded by the kept declarations of res into
+// outDir, at the same path relative to it as to the file declaring them.
+// Files in embedded directories starting with . or _ are skipped unless the
+// pattern has the all: prefix, as for the go command.
+func CopyEmbeds(res *Result, outDir string) error {
+	for _, decl := range res.Decls {
+		srcDir := filepath.Dir(res.Fset.File(decl.Pos()).Name())
+		for _, pattern := range declEmbeds(decl) {
+			pattern, all := strings.CutPrefix(pattern, "all:")
+			matches, err := filepath.Glob(filepath.Join(srcDir, filepath.FromSlash(pattern)))
+			if err != nil {
+				return err
+			}
+			for _, match := range matches {
+				err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return err
+					}
+					if path != match && !all && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+						if d.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					if d.IsDir() {
+						return nil
+					}
+					rel, err := filepath.Rel(srcDir, path)
+					if err != nil {
+						return err
+					}
+					return copyFile(path, filepath.Join(outDir, rel))
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile copies the regular file src to dst, creating its directory.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// exampleFuncs returns the Example functions declared in the _test.go files
+// among files.
+func exampleFuncs(fset *token.FileSet, files []*ast.File) []*ast.FuncDecl {
+	var examples []*ast.FuncDecl
+	for _, file := range files {
+		if !strings.HasSuffix(fset.File(file.Pos()).Name(), "_test.go") {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Example") {
+				examples = append(examples, fn)
+			}
+		}
+	}
+	return examples
+}
+
+// exampleTarget returns the identifier an example function documents, in
+// the form used by keptNames: "Foo" for ExampleFoo and "T.M" for ExampleT_M.
+// A trailing lowercase suffix (ExampleFoo_second) is ignored. The package
+// example, Example, documents no identifier and yields "".
+func exampleTarget(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, "Example"), "_")
+	if n := len(parts); n > 1 {
+		if r, _ := utf8.DecodeRuneInString(parts[n-1]); !unicode.IsUpper(r) {
+			parts = parts[:n-1]
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// keptNames returns the names of the kept functions and types, with methods
+// qualified by their receiver type name as "T.M".
+func keptNames(keptDecls map[ast.Decl]bool, keptSpecs map[ast.Spec]bool) map[string]bool {
+	names := map[string]bool{}
+	for decl := range keptDecls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if fn.Recv == nil {
+			names[fn.Name.Name] = true
+		} else if recv := recvTypeName(fn); recv != "" {
+			names[recv+"."+fn.Name.Name] = true
+		}
+	}
+	for spec := range keptSpecs {
+		if ts, ok := spec.(*ast.TypeSpec); ok {
+			names[ts.Name.Name] = true
+		}
+	}
+	return names
+}
+
+// recvTypeName returns the name of the receiver type of method fn.
+func recvTypeName(fn *ast.FuncDecl) string {
+	expr := fn.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// Graph records the references followed while analyzing an entry file.
+type Graph struct {
+	// Roots are the objects traversal started from.
+	Roots []types.Object
+	// Edges maps an object to the objects its declaration references.
+	Edges map[types.Object][]types.Object
+
+	seen map[[2]types.Object]bool
+}
+
+func newGraph() *Graph {
+	return &Graph{Edges: map[types.Object][]types.Object{}, seen: map[[2]types.Object]bool{}}
+}
+
+func (g *Graph) addRoot(obj types.Object) {
+	if obj != nil {
+		g.Roots = append(g.Roots, obj)
+	}
+}
+
+func (g *Graph) addEdge(from, to types.Object) {
+	key := [2]types.Object{from, to}
+	if from == nil || from == to || g.seen[key] {
+		return
+	}
+	g.seen[key] = true
+	g.Edges[from] = append(g.Edges[from], to)
+}
+
+// Path returns the shortest chain of references leading from a root to
+// obj, both included, or nil if obj was not reached.
+func (g *Graph) Path(obj types.Object) []types.Object {
+	prev := map[types.Object]types.Object{}
+	seen := map[types.Object]bool{}
+	var queue []types.Object
+	for _, r := range g.Roots {
+		if !seen[r] {
+			seen[r] = true
+			queue = append(queue, r)
+		}
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == obj {
+			var path []types.Object
+			for ; cur != nil; cur = prev[cur] {
+				path = append([]types.Object{cur}, path...)
+			}
+			return path
+		}
+		for _, next := range g.Edges[cur] {
+			if !seen[next] {
+				seen[next] = true
+				prev[next] = cur
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// KeptPaths describes, for every kept declaration, the shortest chain of
+// references from a root that caused it to be kept, as "name: a -> b -> name".
+func (r *Result) KeptPaths() []string {
+	var lines []string
+	for _, obj := range r.keptObjects() {
+		var names []string
+		for _, step := range r.Graph.Path(obj) {
+			names = append(names, objectName(step))
+		}
+		lines = append(lines, objectName(obj)+": "+strings.Join(names, " -> "))
+	}
+	return lines
+}
+
+// keptObjects returns the objects declared by the kept declarations.
+func (r *Result) keptObjects() []types.Object {
+	info := r.Pkg.TypesInfo
+	var objs []types.Object
+	for _, decl := range r.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			objs = append(objs, info.Defs[d.Name])
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					objs = append(objs, info.Defs[s.Name])
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						objs = append(objs, info.Defs[name])
+					}
+				}
+			}
+		}
+	}
+	return objs
+}
+
+// objectName names obj for display, qualifying methods as "T.M".
+func objectName(obj types.Object) string {
+	if fn, ok := obj.(*types.Func); ok {
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			if tn := namedTypeName(recv.Type()); tn != nil {
+				return tn.Name() + "." + fn.Name()
+			}
+		}
+	}
+	return obj.Name()
+}
+
+// specObject returns the object declared by spec, the first one for specs
+// declaring several.
+func specObject(spec ast.Spec, info *types.Info) types.Object {
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		return info.Defs[s.Name]
+	case *ast.ValueSpec:
+		return info.Defs[s.Names[0]]
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestKeptPaths(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "selectors", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	paths := map[string]bool{}
+	for _, line := range res.KeptPaths() {
+		paths[line] = true
+	}
+	for _, want := range []string{
+		"Lookup: Lookup",
+		"Store.Get: Lookup -> Store.Get",
+		"suffix: Lookup -> Store.Get -> suffix",
+	} {
+		if !paths[want] {
+			t.Errorf("expected path %q, got %v", want, res.KeptPaths())
+		}
+	}
+}
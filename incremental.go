@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// manifestName is the file, inside the output directory, recording the
+// source files each cut depended on.
+const manifestName = ".gocut-cache.json"
+
+// GitInvoker answers the git queries of incremental mode. Tests substitute a
+// fake implementation.
+type GitInvoker interface {
+	// ChangedFiles returns the absolute paths of the .go files under dir
+	// that differ from ref.
+	ChangedFiles(dir, ref string) ([]string, error)
+}
+
+// execGit runs the git binary.
+type execGit struct{}
+
+func (execGit) ChangedFiles(dir, ref string) ([]string, error) {
+	top, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSpace(string(top))
+	changed, err := exec.Command("git", "-C", root, "diff", "--name-only", "-z", ref, "--", "*.go").Output()
+	if err != nil {
+		return nil, err
+	}
+	// git diff leaves out files that are not tracked yet.
+	untracked, err := exec.Command("git", "-C", root, "ls-files", "--others", "--exclude-standard", "-z", "--", "*.go").Output()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range bytes.Split(append(changed, untracked...), []byte{0}) {
+		if len(name) > 0 {
+			files = append(files, filepath.Join(root, string(name)))
+		}
+	}
+	return files, nil
+}
+
+// cacheManifest maps an absolute entry file path to its last cut.
+type cacheManifest map[string]cacheEntry
+
+// cacheEntry records what a cut was built from.
+type cacheEntry struct {
+	// Files holds the absolute paths of the source files of the cut.
+	Files []string `json:"files"`
+	// Options holds the flags the cut was made with, as outputOptions
+	// returns them.
+	Options string `json:"options"`
+}
+
+// ignoredOptions are the flags that do not change the files written, so
+// the cut is reused whatever their values.
+var ignoredOptions = map[string]bool{
+	"since": true, "quiet": true, "v": true, "log-level": true, "log-json": true,
+	"report": true, "verbose-graph": true, "cpuprofile": true, "memprofile": true,
+}
+
+// outputOptions returns the flags set on fs that shape the output, as
+// space-separated name=value pairs in lexical order.
+func outputOptions(fs *flag.FlagSet) string {
+	var opts []string
+	fs.Visit(func(f *flag.Flag) {
+		if !ignoredOptions[f.Name] {
+			opts = append(opts, f.Name+"="+f.Value.String())
+		}
+	})
+	return strings.Join(opts, " ")
+}
+
+// loadManifest reads the manifest of outputDir. A missing manifest is empty.
+func loadManifest(outputDir string) (cacheManifest, error) {
+	manifest := cacheManifest{}
+	data, err := os.ReadFile(filepath.Join(outputDir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, json.Unmarshal(data, &manifest)
+}
+
+// save writes the manifest into outputDir.
+func (m cacheManifest) save(outputDir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, manifestName), data, 0644)
+}
+
+// upToDate reports whether the existing cut of entry at outPath can be
+// reused: it must exist, be recorded in the manifest with the same options,
+// and none of the files it was built from, nor any file of the entry's
+// package, may have changed or been added since ref. A new file of the
+// package may change kept declarations, such as an init setting a kept
+// variable.
+func upToDate(git GitInvoker, ref, entry, outPath, options string, manifest cacheManifest) (bool, error) {
+	cached, ok := manifest[entry]
+	if !ok || cached.Options != options {
+		return false, nil
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return false, nil
+	}
+	changed, err := git.ChangedFiles(filepath.Dir(entry), ref)
+	if err != nil {
+		return false, err
+	}
+	changedSet := map[string]bool{}
+	for _, f := range changed {
+		if filepath.Dir(f) == filepath.Dir(entry) {
+			return false, nil
+		}
+		changedSet[f] = true
+	}
+	for _, dep := range cached.Files {
+		if changedSet[dep] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeGit reports a fixed set of changed files.
+type fakeGit struct {
+	changed []string
+}
+
+func (g *fakeGit) ChangedFiles(dir, ref string) ([]string, error) {
+	return g.changed, nil
+}
+
+func TestUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	entry := filepath.Join(dir, "entry.go")
+	dep := filepath.Join(dir, "dep.go")
+	other := filepath.Join(dir, "other", "other.go")
+	setup := filepath.Join(dir, "setup.go")
+	outPath := filepath.Join(dir, "out", "entry.go")
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outPath, []byte("package x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := cacheManifest{entry: {Files: []string{entry, dep}, Options: "mirror=true"}}
+
+	tests := []struct {
+		name     string
+		changed  []string
+		options  string
+		manifest cacheManifest
+		want     bool
+	}{
+		{"unrelated change", []string{other}, "mirror=true", manifest, true},
+		{"entry changed", []string{entry}, "mirror=true", manifest, false},
+		{"dependency changed", []string{other, dep}, "mirror=true", manifest, false},
+		{"file added to the package", []string{setup}, "mirror=true", manifest, false},
+		{"options changed", nil, "mirror=true tabwidth=4", manifest, false},
+		{"not cached", nil, "mirror=true", cacheManifest{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := upToDate(&fakeGit{changed: tt.changed}, "HEAD", entry, outPath, tt.options, tt.manifest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("upToDate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "directives", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := (cacheManifest{absEntry: {Files: res.Files()}}).save(dir); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		t.Fatalf("loadManifest failed: %v", err)
+	}
+
+	deps := map[string]bool{}
+	for _, f := range manifest[absEntry].Files {
+		deps[filepath.Base(f)] = true
+	}
+	if !deps["entry.go"] || !deps["colors.go"] {
+		t.Errorf("expected entry.go and colors.go as dependencies, got %v", manifest[absEntry].Files)
+	}
+}
+
+func TestExecGitChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	root := writeTree(t, map[string]string{
+		"go.mod":      "module example.com/inc\n\ngo 1.23\n",
+		"entry.go":    "package inc\n",
+		"my file.go":  "package inc\n",
+		"same.go":     "package inc\n",
+		".gitignore":  "ignored.go\n",
+		"ignored.go":  "package inc\n",
+		"notes.txt":   "notes\n",
+		"sub/deep.go": "package sub\n",
+	})
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=gocut", "-c", "user.email=gocut@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", "entry.go", "my file.go", "same.go", ".gitignore")
+	git("commit", "-q", "-m", "initial")
+	for name, src := range map[string]string{"entry.go": "package inc\n\nvar x int\n", "my file.go": "package inc\n\nvar y int\n", "new.go": "package inc\n"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := execGit{}.ChangedFiles(root, "HEAD")
+	if err != nil {
+		t.Fatalf("ChangedFiles failed: %v", err)
+	}
+	// Names with spaces survive, and untracked files count as changed
+	// unless they are ignored.
+	var names []string
+	for _, f := range changed {
+		rel, err := filepath.Rel(root, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	slices.Sort(names)
+	if want := []string{"entry.go", "my file.go", "new.go", "sub/deep.go"}; !slices.Equal(names, want) {
+		t.Errorf("expected changed files %v, got %v", want, names)
+	}
+}
+
+func TestOutputOptions(t *testing.T) {
+	fs := flag.NewFlagSet("gocut", flag.ContinueOnError)
+	fs.Bool("mirror", false, "")
+	fs.Int("tabwidth", 8, "")
+	fs.String("since", "", "")
+	fs.Bool("quiet", false, "")
+	if err := fs.Parse([]string{"-tabwidth", "4", "-since", "HEAD~1", "-quiet", "-mirror"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := outputOptions(fs), "mirror=true tabwidth=4"; got != want {
+		t.Errorf("outputOptions = %q, want %q", got, want)
+	}
+}
+
+func TestUpToDateUntrackedFile(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	root := writeTree(t, map[string]string{
+		"go.mod":       "module example.com/inc\n\ngo 1.23\n",
+		"entry.go":     "package inc\n\nfunc Get() int {\n\treturn Level\n}\n",
+		"level.go":     "package inc\n\nvar Level = 1\n",
+		"out/entry.go": "package inc\n",
+	})
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"commit", "-q", "-m", "initial"}} {
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=gocut", "-c", "user.email=gocut@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	entry, outPath := filepath.Join(root, "entry.go"), filepath.Join(root, "out", "entry.go")
+	manifest := cacheManifest{entry: {Files: []string{entry, filepath.Join(root, "level.go")}}}
+
+	if fresh, err := upToDate(execGit{}, "HEAD", entry, outPath, "", manifest); err != nil || !fresh {
+		t.Fatalf("expected the cut to be up to date, got %v (%v)", fresh, err)
+	}
+	// The new file changes the kept variable without touching the files
+	// the cut was built from.
+	if err := os.WriteFile(filepath.Join(root, "setup.go"), []byte("package inc\n\nfunc init() {\n\tLevel = 3\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fresh, err := upToDate(execGit{}, "HEAD", entry, outPath, "", manifest); err != nil || fresh {
+		t.Errorf("expected the untracked setup.go to make the cut stale, got %v (%v)", fresh, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// declRef locates the declaration of an object: the top-level declaration
+// and, for grouped declarations, the spec within it.
+type declRef struct {
+	decl ast.Decl
+	spec ast.Spec
+}
+
+// declIndex maps the objects of a package to their declarations.
+type declIndex struct {
+	objects map[types.Object]declRef
+	// methods holds the method declarations by name. Methods are matched by
+	// name so that calls through an interface keep the implementations.
+	methods map[string][]*ast.FuncDecl
+	// inits holds the package's init functions.
+	inits []*ast.FuncDecl
+}
+
+// newDeclIndex indexes the top-level declarations of files.
+func newDeclIndex(files []*ast.File, info *types.Info) *declIndex {
+	x := &declIndex{objects: map[types.Object]declRef{}, methods: map[string][]*ast.FuncDecl{}}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv != nil {
+					x.methods[d.Name.Name] = append(x.methods[d.Name.Name], d)
+				} else if obj := info.Defs[d.Name]; obj != nil {
+					x.objects[obj] = declRef{decl: d}
+					if d.Name.Name == "init" {
+						x.inits = append(x.inits, d)
+					}
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if obj := info.Defs[s.Name]; obj != nil {
+							x.objects[obj] = declRef{decl: d, spec: s}
+						}
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							if obj := info.Defs[name]; obj != nil {
+								x.objects[obj] = declRef{decl: d, spec: s}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return x
+}
+
+// lookup returns the declarations to keep for obj: its own declaration, or
+// for a method every method declaration of that name.
+func (x *declIndex) lookup(obj types.Object) []declRef {
+	if fn, ok := obj.(*types.Func); ok && fn.Type().(*types.Signature).Recv() != nil {
+		var refs []declRef
+		for _, d := range x.methods[fn.Name()] {
+			refs = append(refs, declRef{decl: d})
+		}
+		return refs
+	}
+	if fn, ok := obj.(*types.Func); ok {
+		obj = fn.Origin()
+	}
+	if ref, ok := x.objects[obj]; ok {
+		return []declRef{ref}
+	}
+	return nil
+}
+
+// assertion is an interface satisfaction check such as
+// var _ Iface = (*T)(nil).
+type assertion struct {
+	obj      types.Object
+	iface    *types.Interface
+	concrete types.Type
+}
+
+// assertions returns the interface satisfaction checks among the indexed
+// blank variables.
+func (x *declIndex) assertions(info *types.Info) []assertion {
+	var result []assertion
+	for obj, ref := range x.objects {
+		v, ok := obj.(*types.Var)
+		if !ok || v.Name() != "_" || !types.IsInterface(v.Type()) {
+			continue
+		}
+		spec := ref.spec.(*ast.ValueSpec)
+		for i, name := range spec.Names {
+			if info.Defs[name] == obj && i < len(spec.Values) {
+				result = append(result, assertion{
+					obj:      obj,
+					iface:    v.Type().Underlying().(*types.Interface),
+					concrete: info.TypeOf(spec.Values[i]),
+				})
+			}
+		}
+	}
+	return result
+}
+
+// methods returns the methods of the concrete type implementing the
+// interface.
+func (as assertion) methods() []types.Object {
+	var methods []types.Object
+	for i := 0; i < as.iface.NumMethods(); i++ {
+		m := as.iface.Method(i)
+		if obj, _, _ := types.LookupFieldOrMethod(as.concrete, true, m.Pkg(), m.Name()); obj != nil {
+			methods = append(methods, obj)
+		}
+	}
+	return methods
+}
+
+// referencesAny reports whether node refers to a package-level variable of
+// pkg in vars.
+func referencesAny(node ast.Node, info *types.Info, pkg *types.Package, vars map[types.Object]bool) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if v, ok := info.Uses[id].(*types.Var); ok && vars[v] && v.Pkg() == pkg && v.Parent() == pkg.Scope() {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
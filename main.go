@@ -1,134 +1,1138 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/format"
-	"go/parser"
+	"go/build/constraint"
+	"go/printer"
 	"go/token"
 	"go/types"
-	"log"
+	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the command line and returns the exit status of the process:
+// 0 on success and 1 if the cut failed.
+func run() int {
 	inputPath := flag.String("input", "", "Input entry Go file path")
+	inputGlob := flag.String("input-glob", "", "Glob of entry Go files, possibly of several packages of the module, each cut to its own output under -output at its path relative to the working directory")
+	inputList := flag.String("input-list", "", "File listing entry Go files of one package, one per line, whose declarations are all roots")
 	outputDir := flag.String("output", "output", "Output directory for filtered source files")
+	strict := flag.Bool("strict", false, "Fail instead of warning when nothing is reachable from the entry file")
+	tags := flag.String("tags", "", "Comma-separated build tags to select files with")
+	workspace := flag.String("workspace", "", "go.work file (or directory containing it) used to resolve modules")
+	moduleRoot := flag.String("module-root", "", "Directory packages are loaded from, and relative -input paths are resolved against, instead of the entry's directory")
+	outputFormat := flag.String("output-format", "source", "Output format: source writes the cut file, patch prints JSON edits removing dead declarations")
+	testdata := flag.Bool("testdata", false, "Emit the output as golden testdata with a generated-code header")
+	report := flag.String("report", "", "Print a report of the cut to stdout; supported: json, csv")
+	keepRange := flag.String("keep-range", "", "Use the declarations overlapping file.go:start-end as roots instead of the entry file")
+	verboseGraph := flag.Bool("verbose-graph", false, "Print the chain of references that caused each declaration to be kept")
+	keepExamples := flag.Bool("keep-examples", false, "With -mirror, keep the Example functions of test files documenting kept symbols")
+	localPrefix := flag.String("local", "", "Comma-separated import path prefixes goimports groups after third-party imports")
+	noGoimports := flag.Bool("no-goimports", false, "Only gofmt the output instead of running goimports on it")
+	since := flag.String("since", "", "Only regenerate the output if the entry or its dependencies changed since this git ref")
+	failOnDeprecated := flag.Bool("fail-on-deprecated", false, "Fail if a kept declaration is marked Deprecated in its doc comment")
+	mirror := flag.Bool("mirror", false, "Write one output file per source file of the package instead of a single cut file")
+	split := flag.Int("split", 0, "Distribute the kept declarations over this many output files of the same package")
+	outputMode := flag.String("output-mode", "overwrite", "What to do with output files that exist already: overwrite, skip or error")
+	pruneEmpty := flag.Bool("prune-empty-files", false, "With -mirror, skip files left without declarations")
+	logLevel := flag.String("log-level", "info", "Minimum level of logged events: debug, info, warn or error")
+	logJSON := flag.Bool("log-json", false, "Log events as JSON lines instead of text")
+	goos := flag.String("goos", "", "Target operating system files are selected for, as for GOOS")
+	goosMatrix := flag.String("goos-matrix", "", "Comma-separated GOOS values to cut for, each written to a file suffixed with _<goos>")
+	copyEmbeds := flag.Bool("copy-embeds", false, "Copy the files embedded by kept //go:embed variables next to the output")
+	outputPackage := flag.String("output-package", "", "Package name of the output, replacing the one of the input")
+	stubBodies := flag.Bool("stub-bodies", false, "Replace the bodies of kept exported functions with a panic, keeping only their signatures' dependencies")
+	noInline := flag.String("no-inline", "", "With -pattern, comma-separated import path prefixes of packages left as imports instead of being cut")
+	rootsMode := flag.String("roots", "entry", "Roots of the cut: entry for the declarations of the entry file, exported for the exported API of its package")
+	rootFuncs := flag.String("root-func", "", "Comma-separated functions of the entry package to use as roots besides the entry file")
+	skipGenerated := flag.Bool("skip-generated", false, "With -pattern, leave the packages made only of generated files imported instead of cutting them")
+	keepGenerated := flag.Bool("keep-generated", false, "Keep every declaration of the generated files of the cut packages")
+	keepTagged := flag.String("keep-tagged", "", "Keep every method of kept structs with a field carrying this struct tag key, and of their field types")
+	var excludePackages []string
+	flag.Func("exclude-package", "With -pattern, import path of a package left as an import instead of being cut; may be repeated", func(path string) error {
+		excludePackages = append(excludePackages, path)
+		return nil
+	})
+	keepAllMethods := flag.Bool("keep-all-methods", false, "Keep every method of the kept types, not only those called by name")
+	failOnMissing := flag.Bool("fail-on-missing", false, "Type-check the output before writing it and exit with status 1, listing its errors, if it does not compile")
+	fragment := flag.Bool("fragment", false, "Print the kept declarations to stdout, separated by blank lines, without package clause or imports, and write no files")
+	countOnly := flag.Bool("count-only", false, "Print the number of reachable declarations to stdout and write no files")
+	verbose := flag.Bool("v", false, "With -count-only, also print the count of each kind of declaration")
+	keepImports := flag.String("keep-imports", "", "Comma-separated import paths the output imports even if nothing kept refers to them")
+	after := flag.String("after", "", "Command run on each written file after its imports are fixed, with {} replaced by the file's path")
+	normalizeWhitespace := flag.Bool("normalize-whitespace", false, "Run the output through gofmt until it no longer changes, for whitespace that is canonical however the sources were spaced")
+	trimBlankLines := flag.Bool("trim-blank-lines", false, "Collapse runs of blank lines left in the output, such as in block comments, to one, outside of string literals")
+	tabWidth := flag.Int("tabwidth", 8, "Width of a tab stop in the output")
+	useSpaces := flag.Bool("use-spaces", false, "Indent the output with spaces instead of tabs")
+	dedupeImports := flag.Bool("dedupe-imports-across-files", false, "With -mirror, -split or -pattern, import each package under the same name in every output file")
+	quiet := flag.Bool("quiet", false, "Only log the success line, not the kept symbols and the summary")
+	pattern := flag.String("pattern", "", "Space-separated package patterns to cut instead of the package of -input, such as ./...")
+	rootNames := flag.String("root", "", "With -pattern, comma-separated roots qualified as import/path.Name; defaults to the main functions")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	memProfile := flag.String("memprofile", "", "Write a heap profile taken at the end of the run to this file")
 	flag.Parse()
 
-	if *inputPath == "" {
-		log.Println("Please specify the input Go file path using -input flag")
-		return
+	if err := setupLogging(*logLevel, *logJSON); err != nil {
+		slog.Error("Invalid logging flags", "err", err)
+		return 1
+	}
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		slog.Error("Profiling failed", "err", err)
+		return 1
+	}
+	defer stopProfiles()
+	if *inputPath == "" && *inputList == "" && *pattern == "" && *inputGlob == "" {
+		slog.Error("Please specify the input Go file path using -input flag")
+		return 1
+	}
+	if *pattern != "" && (*since != "" || *outputFormat == "patch" || *goosMatrix != "" || *keepRange != "" || *split > 0 || *rootFuncs != "") {
+		slog.Error("-pattern cannot be combined with -since, -goos-matrix, -keep-range, -root-func, -split or -output-format patch")
+		return 1
+	}
+	if *inputGlob != "" && (*inputPath != "" || *inputList != "" || *pattern != "" || *since != "" || *goosMatrix != "" || *keepRange != "" || *mirror || *split > 0 || *failOnMissing || *outputFormat == "patch") {
+		slog.Error("-input-glob cannot be combined with -input, -input-list, -pattern, -since, -goos-matrix, -keep-range, -mirror, -split, -fail-on-missing or -output-format patch")
+		return 1
+	}
+	if *outputMode != "overwrite" && *outputMode != "skip" && *outputMode != "error" {
+		slog.Error("Unknown output mode", "mode", *outputMode)
+		return 1
+	}
+	if *skipGenerated && (*keepGenerated || *pattern == "") {
+		// The generated files of a cut package must come with it to compile.
+		slog.Error("-skip-generated requires -pattern and cannot be combined with -keep-generated")
+		return 1
+	}
+	if *rootsMode != "entry" && *rootsMode != "exported" {
+		slog.Error("Unknown roots", "roots", *rootsMode)
+		return 1
+	}
+	if *rootsMode == "exported" && (*pattern != "" || *keepRange != "") {
+		slog.Error("-roots exported cannot be combined with -pattern or -keep-range")
+		return 1
+	}
+	if *outputFormat != "source" && *outputFormat != "patch" {
+		slog.Error("Unknown output format", "format", *outputFormat)
+		return 1
+	}
+	if *report != "" && *report != "json" && *report != "csv" {
+		slog.Error("Unknown report format", "format", *report)
+		return 1
+	}
+	if *goosMatrix != "" && (*since != "" || *outputFormat == "patch" || *mirror) {
+		slog.Error("-goos-matrix cannot be combined with -since, -mirror or -output-format patch")
+		return 1
+	}
+	if *fragment && (*pattern != "" || *mirror || *split > 0 || *outputFormat == "patch") {
+		slog.Error("-fragment cannot be combined with -pattern, -mirror, -split or -output-format patch")
+		return 1
+	}
+	if *split > 0 && (*mirror || *since != "") {
+		// The cache tracks one output file per entry, not the split parts.
+		slog.Error("-split cannot be combined with -mirror or -since")
+		return 1
+	}
+	if *noInline != "" && *pattern == "" {
+		slog.Error("-no-inline requires -pattern")
+		return 1
+	}
+	if len(excludePackages) > 0 && *pattern == "" {
+		slog.Error("-exclude-package requires -pattern")
+		return 1
+	}
+	if *failOnMissing && (*pattern != "" || *split > 0 || *fragment || *countOnly || *outputFormat == "patch") {
+		// Only single and mirrored cut files are verified.
+		slog.Error("-fail-on-missing cannot be combined with -pattern, -split, -fragment, -count-only or -output-format patch")
+		return 1
+	}
+	if *countOnly && *since != "" {
+		// Nothing is written, so there is no output to be up to date.
+		slog.Error("-count-only cannot be combined with -since")
+		return 1
+	}
+	if *keepExamples && (!*mirror || *pattern != "") {
+		// Only the mirrored _test.go files can hold the examples.
+		slog.Error("-keep-examples requires -mirror and cannot be combined with -pattern")
+		return 1
 	}
 
-	usedSymbols, decls, err := CollectUsedDeclarations(*inputPath)
+	entries := []string{*inputPath}
+	if *inputList != "" {
+		var err error
+		if entries, err = readInputList(*inputList); err != nil {
+			slog.Error("Reading input list failed", "err", err)
+			return 1
+		}
+	}
+	outPath := filepath.Join(*outputDir, filepath.Base(entries[0]))
+	var manifest cacheManifest
+	analyzer := &Analyzer{Workspace: *workspace, ModuleRoot: *moduleRoot, Tags: *tags, KeepExamples: *keepExamples, StubBodies: *stubBodies, KeepAllMethods: *keepAllMethods, KeepTagged: *keepTagged, ExportedRoots: *rootsMode == "exported", SkipGenerated: *skipGenerated, KeepGenerated: *keepGenerated}
+	absInput, err := analyzer.entryPath(entries[0])
 	if err != nil {
-		log.Println("Analysis failed:", err)
-		return
+		slog.Error("Analysis failed", "err", err)
+		return 1
+	}
+	if *since != "" {
+		if manifest, err = loadManifest(*outputDir); err != nil {
+			slog.Error("Reading cache failed", "err", err)
+			return 1
+		}
+		fresh, err := upToDate(execGit{}, *since, absInput, outPath, outputOptions(flag.CommandLine), manifest)
+		if err != nil {
+			slog.Error("Querying git failed", "err", err)
+			return 1
+		}
+		if fresh {
+			slog.Info("Up to date, skipping", "output", outPath)
+			return 0
+		}
 	}
 
-	log.Println("Recursive dependency declarations in the entry file:")
-	for name := range usedSymbols {
-		log.Println("  ", name)
+	var keptImports []string
+	if *keepImports != "" {
+		keptImports = strings.Split(*keepImports, ",")
+	}
+	if *rootFuncs != "" {
+		analyzer.RootFuncs = strings.Split(*rootFuncs, ",")
 	}
+	if *keepRange != "" {
+		if analyzer.KeepRange, err = ParseLineRange(*keepRange); err != nil {
+			slog.Error("Analysis failed", "err", err)
+			return 1
+		}
+	}
+	if *pattern != "" {
+		analyzer.GOOS = *goos
+		if *noInline != "" {
+			analyzer.NoInline = strings.Split(*noInline, ",")
+		}
+		analyzer.Exclude = excludePackages
+		var roots []string
+		if *rootNames != "" {
+			roots = strings.Split(*rootNames, ",")
+		}
+		dir := "."
+		if *moduleRoot != "" {
+			dir = *moduleRoot
+		}
+		results, err := analyzer.AnalyzePattern(dir, strings.Fields(*pattern), roots)
+		if err != nil {
+			slog.Error("Analysis failed", "err", err)
+			return 1
+		}
+		writeOpts := WriteOptions{Generated: *testdata, LocalPrefix: *localPrefix, PruneEmptyFiles: *pruneEmpty, PackageName: *outputPackage, TabWidth: *tabWidth, UseSpaces: *useSpaces, NormalizeWhitespace: *normalizeWhitespace, TrimBlankLines: *trimBlankLines, OutputMode: *outputMode, KeepImports: keptImports, ConsistentImports: *dedupeImports}
+		// All packages are rendered and their outputs checked before any is
+		// written.
+		outputs := make([][]string, len(results))
+		var paths []string
+		var srcs [][]byte
+		for i, res := range results {
+			var data [][]byte
+			if outputs[i], data, err = mirroredOutputs(res, patternOutputDir(*outputDir, res.Pkg), writeOpts); err != nil {
+				slog.Error("Write failed", "err", err)
+				return 1
+			}
+			paths, srcs = append(paths, outputs[i]...), append(srcs, data...)
+		}
+		written, err := writeOutputs(paths, srcs, writeOpts)
+		if err != nil {
+			slog.Error("Write failed", "err", err)
+			return 1
+		}
+		for i, res := range results {
+			var pkgPaths []string
+			for _, path := range outputs[i] {
+				if slices.Contains(written, path) {
+					pkgPaths = append(pkgPaths, path)
+				}
+			}
+			for _, path := range pkgPaths {
+				if !*noGoimports {
+					autoFixImports(path, writeOpts)
+				}
+				if *after != "" {
+					if err := runAfter(*after, path); err != nil {
+						slog.Error("After command failed", "file", path, "err", err)
+						return 1
+					}
+				}
+			}
+			if *copyEmbeds {
+				if err := CopyEmbeds(res, patternOutputDir(*outputDir, res.Pkg)); err != nil {
+					slog.Error("Copying embedded files failed", "err", err)
+					return 1
+				}
+			}
+			slog.Info("Cut successfully", "package", res.Pkg.PkgPath, "files", len(pkgPaths))
+		}
+		return 0
+	}
+	if *inputGlob != "" {
+		analyzer.GOOS = *goos
+		matches, err := filepath.Glob(*inputGlob)
+		if err != nil || len(matches) == 0 {
+			slog.Error("No entry files match the glob", "glob", *inputGlob, "err", err)
+			return 1
+		}
+		results, err := analyzer.AnalyzeBatch(matches)
+		if err != nil {
+			slog.Error("Analysis failed", "err", err)
+			return 1
+		}
+		writeOpts := WriteOptions{Generated: *testdata, LocalPrefix: *localPrefix, PackageName: *outputPackage, TabWidth: *tabWidth, UseSpaces: *useSpaces, NormalizeWhitespace: *normalizeWhitespace, TrimBlankLines: *trimBlankLines, OutputMode: *outputMode, KeepImports: keptImports}
+		paths := make([]string, len(results))
+		srcs := make([][]byte, len(results))
+		for i, res := range results {
+			var buf bytes.Buffer
+			if paths[i], err = batchOutput(*outputDir, matches[i]); err == nil {
+				err = WriteFilteredSourceTo(&buf, res, writeOpts)
+			}
+			if err != nil {
+				slog.Error("Write failed", "err", err)
+				return 1
+			}
+			srcs[i] = buf.Bytes()
+		}
+		written, err := writeOutputs(paths, srcs, writeOpts)
+		if err != nil {
+			slog.Error("Write failed", "err", err)
+			return 1
+		}
+		for i, path := range paths {
+			if !slices.Contains(written, path) {
+				slog.Info("Output exists, skipping", "output", path)
+				continue
+			}
+			if !*noGoimports {
+				autoFixImports(path, writeOpts)
+			}
+			if *after != "" {
+				if err := runAfter(*after, path); err != nil {
+					slog.Error("After command failed", "file", path, "err", err)
+					return 1
+				}
+			}
+			slog.Info("Cut successfully", "entry", matches[i], "output", path)
+		}
+		return 0
+	}
+	targets := []string{*goos}
+	if *goosMatrix != "" {
+		targets = strings.Split(*goosMatrix, ",")
+	}
+	for _, target := range targets {
+		analyzer.GOOS = target
+		outPath := outPath
+		if *goosMatrix != "" {
+			outPath = matrixOutput(outPath, target)
+		}
+		res, err := analyzer.AnalyzeFiles(entries...)
+		if err != nil {
+			slog.Error("Analysis failed", "err", err)
+			return 1
+		}
+		usedSymbols, decls := res.Used, res.Decls
+		if *strict && len(decls) == 0 {
+			slog.Error("Analysis failed: nothing is reachable", "entry", entries[0])
+			return 1
+		}
+		if *failOnDeprecated {
+			if names := res.Deprecated(); len(names) > 0 {
+				slog.Error("Analysis failed: deprecated declarations are reachable", "decls", strings.Join(names, ", "))
+				return 1
+			}
+		}
 
-	outPath := filepath.Join(*outputDir, filepath.Base(*inputPath))
-	if err := WriteFilteredSource(*inputPath, outPath, decls); err != nil {
-		log.Println("Write failed:", err)
-		return
+		if *countOnly {
+			fmt.Println(countDecls(decls))
+			if *verbose {
+				kinds := declKinds(decls)
+				for _, kind := range []string{"func", "method", "type", "var", "const"} {
+					fmt.Printf("%s: %d\n", kind, kinds[kind])
+				}
+			}
+			continue
+		}
+
+		if *fragment {
+			frags, err := FragmentsWithOptions(res, WriteOptions{NormalizeWhitespace: *normalizeWhitespace, TrimBlankLines: *trimBlankLines})
+			if err != nil {
+				slog.Error("Formatting failed", "err", err)
+				return 1
+			}
+			fmt.Print(strings.Join(frags, "\n"))
+			continue
+		}
+
+		if *outputFormat == "patch" {
+			edits, err := ComputeEdits(res)
+			if err != nil {
+				slog.Error("Patch failed", "err", err)
+				return 1
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(edits); err != nil {
+				slog.Error("Patch failed", "err", err)
+				return 1
+			}
+			return 0
+		}
+
+		writeOpts := WriteOptions{Generated: *testdata, LocalPrefix: *localPrefix, PruneEmptyFiles: *pruneEmpty, PackageName: *outputPackage, TabWidth: *tabWidth, UseSpaces: *useSpaces, NormalizeWhitespace: *normalizeWhitespace, TrimBlankLines: *trimBlankLines, OutputMode: *outputMode, KeepImports: keptImports, ConsistentImports: *dedupeImports}
+		if *failOnMissing {
+			files := map[string]*bytes.Buffer{}
+			if *mirror {
+				err = WriteMirroredSourceTo(files, res, writeOpts)
+			} else {
+				files[filepath.Base(outPath)] = new(bytes.Buffer)
+				err = WriteFilteredSourceTo(files[filepath.Base(outPath)], res, writeOpts)
+			}
+			var missing []string
+			if err == nil {
+				missing, err = Verify(res, files)
+			}
+			if err != nil {
+				slog.Error("Verification failed", "err", err)
+				return 1
+			}
+			for _, ref := range missing {
+				slog.Error("Type error in the cut", "err", ref)
+			}
+			if len(missing) > 0 {
+				slog.Error("Verification failed: the cut does not type-check", "count", len(missing))
+				return 1
+			}
+		}
+
+		if !*quiet {
+			slog.Info("Recursive dependency declarations in the entry file")
+			for name := range usedSymbols {
+				slog.Info("Used", "symbol", name)
+			}
+		}
+		if *verboseGraph {
+			slog.Info("Reasons declarations were kept")
+			for _, line := range res.KeptPaths() {
+				slog.Info("Kept", "path", line)
+			}
+		}
+
+		start := time.Now()
+		var outPaths []string
+		if *mirror {
+			outPath = *outputDir
+			outPaths, err = WriteMirroredSource(res, outPath, writeOpts)
+		} else if *split > 0 {
+			outPaths, err = WriteSplitSource(res, outPath, *split, writeOpts)
+		} else if outPaths, err = writeFilteredSource(res, outPath, writeOpts); err == nil && len(outPaths) == 0 {
+			slog.Info("Output exists, skipping", "output", outPath)
+			continue
+		}
+		if err != nil {
+			slog.Error("Write failed", "err", err)
+			return 1
+		}
+		if *copyEmbeds {
+			if err := CopyEmbeds(res, *outputDir); err != nil {
+				slog.Error("Copying embedded files failed", "err", err)
+				return 1
+			}
+		}
+		var output []byte
+		for _, path := range outPaths {
+			if !*noGoimports {
+				autoFixImports(path, writeOpts)
+			}
+			if *after != "" {
+				if err := runAfter(*after, path); err != nil {
+					slog.Error("After command failed", "file", path, "err", err)
+					return 1
+				}
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				slog.Error("Summary failed", "err", err)
+				return 1
+			}
+			output = append(output, src...)
+		}
+		slog.Debug("Phase done", "phase", "write", "files", len(outPaths), "duration", time.Since(start))
+		summary, err := Summarize(res, output)
+		if err != nil {
+			slog.Error("Summary failed", "err", err)
+			return 1
+		}
+		if !*quiet {
+			slog.Info("Summary", "kept", summary.DeclsKept, "dropped", summary.DeclsDropped,
+				"original_bytes", summary.OriginalBytes, "output_bytes", summary.OutputBytes,
+				"reduction_percent", fmt.Sprintf("%.1f", summary.ReductionPercent))
+		}
+		if *report == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(Report{Entry: absInput, Output: outPath, Kept: declNames(decls), Removed: RemovedDecls(res), Summary: summary}); err != nil {
+				slog.Error("Report failed", "err", err)
+				return 1
+			}
+		} else if *report == "csv" {
+			if err := WriteUsageCSV(os.Stdout, res); err != nil {
+				slog.Error("Report failed", "err", err)
+				return 1
+			}
+		}
+		if *since != "" {
+			manifest[absInput] = cacheEntry{Files: res.Files(), Options: outputOptions(flag.CommandLine)}
+			if err := manifest.save(*outputDir); err != nil {
+				slog.Error("Writing cache failed", "err", err)
+				return 1
+			}
+		}
+		if *split > 0 {
+			slog.Info("Cut successfully", "outputs", outPaths)
+		} else {
+			slog.Info("Cut successfully", "output", outPath)
+		}
+	}
+	return 0
+}
+
+// setupLogging installs the default slog logger for the given level name.
+// Events are logged as JSON lines if json is set, and as text through the
+// standard logger otherwise.
+func setupLogging(level string, json bool) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	if json {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+	} else {
+		slog.SetLogLoggerLevel(l)
+	}
+	return nil
+}
+
+// startProfiles starts writing a CPU profile to cpu, if set, and returns a
+// function stopping it and writing a heap profile to mem, if set.
+func startProfiles(cpu, mem string) (func(), error) {
+	var cpuFile *os.File
+	if cpu != "" {
+		f, err := os.Create(cpu)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpuFile = f
+	}
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				slog.Error("Writing CPU profile failed", "err", err)
+			}
+		}
+		if mem == "" {
+			return
+		}
+		f, err := os.Create(mem)
+		if err != nil {
+			slog.Error("Writing heap profile failed", "err", err)
+			return
+		}
+		defer f.Close()
+		// Collect garbage to report the live heap accurately.
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			slog.Error("Writing heap profile failed", "err", err)
+		}
+	}, nil
+}
+
+// Analyzer holds the settings used to load the entry package and compute the
+// declarations reachable from the entry file.
+type Analyzer struct {
+	// Workspace is the go.work file used to resolve modules. A directory
+	// containing go.work is accepted too. Empty leaves the lookup to the go
+	// command.
+	Workspace string
+	// ModuleRoot, if set, is the directory packages are loaded from instead
+	// of the directory of the entry file, and the one relative entry paths
+	// are resolved against.
+	ModuleRoot string
+	// Tags is the comma-separated list of build tags files are selected
+	// with, as for go build -tags.
+	Tags string
+	// Tests loads the _test.go files of the entry package as well, and
+	// keeps the package's TestMain, as it does for a _test.go entry.
+	Tests bool
+	// KeepExamples retains the Example functions of the package's test
+	// files documenting a kept function, type or method. It implies Tests.
+	KeepExamples bool
+	// KeepFunc, if set, customizes which objects are kept. It is consulted
+	// for every package-level object and method of the entry package, which
+	// becomes an extra root when force is true, and for every object reached
+	// during traversal, which is skipped along with its dependencies when
+	// drop is true.
+	KeepFunc func(obj types.Object) (force, drop bool)
+	// KeepRange, if set, replaces the declarations of the entry file as
+	// roots with the top-level declarations overlapping the range. A
+	// relative range file is resolved like the entry.
+	KeepRange *LineRange
+	// GOOS is the target operating system files are selected for. Empty
+	// uses the one of the environment.
+	GOOS string
+	// KeepAllMethods keeps every method of the kept named types. Methods
+	// are otherwise kept when a method of that name is called, which covers
+	// calls through interfaces, including interface-typed fields, but not
+	// methods only needed to satisfy an interface a value is converted to.
+	KeepAllMethods bool
+	// ExportedRoots replaces the declarations of the entry file as roots
+	// with the exported functions, types, variables and constants of the
+	// entry package, to cut its public API out as an SDK. The exported
+	// methods of the kept exported types are kept too, whether or not they
+	// are called.
+	ExportedRoots bool
+	// SkipGenerated makes AnalyzePattern leave the packages made only of
+	// generated files, those with a "Code generated ... DO NOT EDIT."
+	// header, imported instead of cutting them.
+	SkipGenerated bool
+	// KeepGenerated keeps every declaration of the generated files of the
+	// cut packages, as extra roots.
+	KeepGenerated bool
+	// RootFuncs names package-level functions of the entry package used as
+	// roots in addition to the declarations of the entry file.
+	RootFuncs []string
+	// KeepTagged, if set, names a struct tag key: every method of the kept
+	// structs with a field carrying it, and of their fields' types, is kept
+	// for the reflection-driven frameworks reading such structs.
+	KeepTagged string
+	// NoInline lists the import path prefixes of the packages AnalyzePattern
+	// leaves as imports instead of cutting them along with the others.
+	NoInline []string
+	// Exclude lists import paths AnalyzePattern leaves as imports, matching
+	// the packages exactly rather than by prefix.
+	Exclude []string
+	// StubBodies replaces the bodies of kept exported functions and methods
+	// with a panic, keeping only what their signatures need.
+	StubBodies bool
+}
+
+// Result is the outcome of analyzing an entry file.
+type Result struct {
+	// Used holds the names of all objects reachable from the entry file.
+	Used map[string]bool
+	// Decls holds the reachable declarations in source order. Grouped
+	// declarations are trimmed to their reachable specs.
+	Decls []ast.Decl
+	// Graph records the references that led to each object being kept.
+	Graph *Graph
+
+	Fset *token.FileSet
+	Pkg  *packages.Package
+	// Entry is the entry file, or nil for results of AnalyzePattern.
+	Entry *ast.File
+	// Overlay holds the content of the files analyzed from memory instead
+	// of from disk, by absolute path, as passed to AnalyzeSource.
+	Overlay map[string][]byte
+}
+
+// source returns the content of the source file name, from the overlay if
+// it was analyzed from memory.
+func (r *Result) source(name string) ([]byte, error) {
+	if src, ok := r.Overlay[name]; ok {
+		return src, nil
+	}
+	return os.ReadFile(name)
+}
+
+// Files returns the source files the entry file and the kept declarations
+// come from.
+func (r *Result) Files() []string {
+	seen := map[string]bool{}
+	var files []string
+	add := func(pos token.Pos) {
+		name := r.Fset.File(pos).Name()
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+	if r.Entry != nil {
+		add(r.Entry.Pos())
 	}
-	autoFixImports(outPath)
-	log.Println("Cut successfully, ", outPath)
+	for _, decl := range r.Decls {
+		add(decl.Pos())
+	}
+	return files
 }
 
+// CollectUsedDeclarations analyzes entryFile with the default settings.
 func CollectUsedDeclarations(entryFile string) (map[string]bool, []ast.Decl, error) {
+	res, err := (&Analyzer{}).Analyze(entryFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Used, res.Decls, nil
+}
+
+// Analyze loads the package of entryFile and collects every declaration
+// reachable from the declarations of entryFile.
+func (a *Analyzer) Analyze(entryFile string) (*Result, error) {
+	return a.analyze([]string{entryFile}, nil)
+}
+
+// AnalyzeFiles is like Analyze for several entry files of the same package,
+// collecting the declarations reachable from any of them. The first one is
+// the Entry of the result.
+func (a *Analyzer) AnalyzeFiles(entryFiles ...string) (*Result, error) {
+	if len(entryFiles) == 0 {
+		return nil, fmt.Errorf("no entry files")
+	}
+	return a.analyze(entryFiles, nil)
+}
+
+// AnalyzeBatch is like Analyze for each of entryFiles, which may belong to
+// different packages of the module, and returns a result per entry file.
+// The packages are loaded, and their declarations indexed, once for all.
+func (a *Analyzer) AnalyzeBatch(entryFiles []string) ([]*Result, error) {
+	if len(entryFiles) == 0 {
+		return nil, fmt.Errorf("no entry files")
+	}
+	fset, pkgs, entryFiles, err := a.loadPackages(entryFiles, nil)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	cache := map[*types.Package]*declIndex{}
+	results := make([]*Result, len(entryFiles))
+	for i, entryFile := range entryFiles {
+		l, err := a.entryRoots(fset, pkgs, []string{entryFile})
+		if err != nil {
+			return nil, err
+		}
+		r := a.collect(fset, []*packages.Package{l.pkg}, l.roots, cache, nil)
+		results[i] = &Result{Used: r.used, Decls: r.decls(fset, l.pkg.Syntax), Graph: r.graph, Fset: fset, Pkg: l.pkg, Entry: l.entry}
+	}
+	slog.Debug("Phase done", "phase", "traverse", "entries", len(results), "duration", time.Since(start))
+	return results, nil
+}
+
+// AnalyzeSource is like Analyze, with src standing in for the content of
+// filename, which may be an unsaved buffer. A relative filename is resolved
+// against dir, the directory of the package it belongs to.
+func (a *Analyzer) AnalyzeSource(filename string, src []byte, dir string) (*Result, error) {
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	entryFile, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	return a.analyze([]string{entryFile}, map[string][]byte{entryFile: src})
+}
+
+// analyze implements Analyze, loading the package with overlay replacing
+// the content of the files it maps.
+func (a *Analyzer) analyze(entryFiles []string, overlay map[string][]byte) (*Result, error) {
+	l, err := a.load(entryFiles, overlay)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	r := a.collect(l.fset, []*packages.Package{l.pkg}, l.roots, nil, nil)
+	decls := r.decls(l.fset, l.pkg.Syntax)
+	slog.Debug("Phase done", "phase", "traverse", "symbols", len(r.used), "decls", len(decls), "duration", time.Since(start))
+
+	return &Result{Used: r.used, Decls: decls, Graph: r.graph, Fset: l.fset, Pkg: l.pkg, Entry: l.entry, Overlay: overlay}, nil
+}
+
+// Walk loads the package of entryFile like Analyze and returns an iterator
+// over the reachable declarations, which runs the traversal as it goes. It
+// yields each declaration as it is discovered, with the object it declares,
+// instead of collecting them all first. A grouped declaration is yielded,
+// untrimmed, once for each of its reachable specs.
+func (a *Analyzer) Walk(entryFile string) (iter.Seq2[types.Object, ast.Decl], error) {
+	l, err := a.load([]string{entryFile}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(types.Object, ast.Decl) bool) {
+		a.collect(l.fset, []*packages.Package{l.pkg}, l.roots, nil, yield)
+	}, nil
+}
+
+// loaded is an entry package ready to be traversed.
+type loaded struct {
+	fset *token.FileSet
+	pkg  *packages.Package
+	// entry is the first entry file.
+	entry *ast.File
+	roots []ast.Node
+}
+
+// load loads the package of entryFiles, with overlay replacing the content
+// of the files it maps, and finds the roots of the traversal.
+func (a *Analyzer) load(entryFiles []string, overlay map[string][]byte) (*loaded, error) {
+	fset, pkgs, entryFiles, err := a.loadPackages(entryFiles, overlay)
+	if err != nil {
+		return nil, err
+	}
+	return a.entryRoots(fset, pkgs, entryFiles)
+}
+
+// loadPackages loads the packages holding entryFiles, with overlay
+// replacing the content of the files it maps, and returns them with the
+// absolute paths of entryFiles.
+func (a *Analyzer) loadPackages(entryFiles []string, overlay map[string][]byte) (*token.FileSet, []*packages.Package, []string, error) {
 	fset := token.NewFileSet()
 
+	abs := make([]string, len(entryFiles))
+	queries := make([]string, len(entryFiles))
+	for i, entryFile := range entryFiles {
+		var err error
+		if abs[i], err = a.entryPath(entryFile); err != nil {
+			return nil, nil, nil, err
+		}
+		queries[i] = "file=" + abs[i]
+	}
+	entryFiles = abs
+	env, err := a.env()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dir := filepath.Dir(entryFiles[0])
+	if a.ModuleRoot != "" {
+		dir = a.ModuleRoot
+	}
 	cfg := &packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps,
-		Fset: fset,
-		Dir:  filepath.Dir(entryFile),
-		Env:  os.Environ(),
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps,
+		Fset:    fset,
+		Dir:     dir,
+		Env:     env,
+		Tests:   slices.ContainsFunc(entryFiles, a.loadsTests),
+		Overlay: overlay,
+	}
+	if a.Tags != "" {
+		cfg.BuildFlags = []string{"-tags=" + a.Tags}
 	}
 
-	pkgs, err := packages.Load(cfg, "file="+entryFile)
+	start := time.Now()
+	pkgs, err := packages.Load(cfg, queries...)
 	if err != nil || len(pkgs) == 0 {
-		return nil, nil, fmt.Errorf("failed to load package: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to load package: %w", err)
+	}
+	slog.Debug("Phase done", "phase", "load", "packages", len(pkgs), "duration", time.Since(start))
+	return fset, pkgs, entryFiles, nil
+}
+
+// loadsTests reports whether the package of entryFile is analyzed with its
+// _test.go files.
+func (a *Analyzer) loadsTests(entryFile string) bool {
+	return a.Tests || a.KeepExamples || strings.HasSuffix(entryFile, "_test.go")
+}
+
+// entryRoots finds the package of entryFiles, absolute paths of files of
+// one of pkgs, and the roots of the traversal in it.
+func (a *Analyzer) entryRoots(fset *token.FileSet, pkgs []*packages.Package, entryFiles []string) (*loaded, error) {
+	// A file= query yields every package holding the file, such as the
+	// package and its test variant.
+	pkg := entryPackage(fset, pkgs, entryFiles[0], a.loadsTests(entryFiles[0]))
+	if pkg == nil {
+		return nil, fmt.Errorf("unable to find the entrance AST")
 	}
-	pkg := pkgs[0]
-	info := pkg.TypesInfo
 	files := pkg.Syntax
 
-	var entryAST *ast.File
-	for _, f := range files {
-		if fset.Position(f.Pos()).Filename == entryFile {
-			entryAST = f
-			break
+	var entryASTs []*ast.File
+	for _, entryFile := range entryFiles {
+		var entryAST *ast.File
+		for _, f := range files {
+			if fset.File(f.Pos()).Name() == entryFile {
+				entryAST = f
+				break
+			}
+		}
+		if entryAST == nil {
+			if len(entryASTs) > 0 {
+				return nil, fmt.Errorf("%s is not part of the package of %s", entryFile, entryFiles[0])
+			}
+			return nil, fmt.Errorf("unable to find the entrance AST")
+		}
+		if !hasRootDecls(entryAST) {
+			slog.Warn("Entry file has no declarations, nothing is reachable and the output will be an empty package", "entry", entryFile)
+		}
+		entryASTs = append(entryASTs, entryAST)
+	}
+
+	var roots []ast.Node
+	for _, f := range entryASTs {
+		roots = append(roots, rootNodes(f.Decls, nil)...)
+	}
+	if a.KeepRange != nil {
+		// The range file is relative to the module root, like the entry.
+		path, err := a.entryPath(a.KeepRange.File)
+		if err != nil {
+			return nil, err
+		}
+		if roots, err = a.KeepRange.roots(fset, files, path); err != nil {
+			return nil, err
+		}
+	}
+	if a.ExportedRoots {
+		roots = roots[:0]
+		for _, f := range files {
+			if !strings.HasSuffix(fset.File(f.Pos()).Name(), "_test.go") {
+				roots = append(roots, rootNodes(f.Decls, exportedNode)...)
+			}
+		}
+	}
+	if a.KeepGenerated {
+		roots = append(roots, generatedRoots(pkg)...)
+	}
+	// TestMain sets up and tears down every test of the package.
+	if a.Tests || strings.HasSuffix(entryFiles[0], "_test.go") {
+		if _, ok := pkg.Types.Scope().Lookup("TestMain").(*types.Func); ok {
+			roots = append(roots, patternRoots(pkg, "TestMain")...)
+		}
+	}
+	for _, name := range a.RootFuncs {
+		if _, ok := pkg.Types.Scope().Lookup(name).(*types.Func); !ok {
+			return nil, fmt.Errorf("root func %s not found in package %s", name, pkg.Name)
+		}
+		roots = append(roots, patternRoots(pkg, name)...)
+	}
+	return &loaded{fset: fset, pkg: pkg, entry: entryASTs[0], roots: roots}, nil
+}
+
+// entryPackage returns the first package of pkgs holding the file named
+// entry, preferring its test variant, which also holds the package's _test.go
+// files, if tests is set, and the package itself otherwise. A _test.go entry
+// only belongs to a test variant, the one of the external test package for
+// package foo_test.
+func entryPackage(fset *token.FileSet, pkgs []*packages.Package, entry string, tests bool) *packages.Package {
+	var pkg *packages.Package
+	for _, p := range pkgs {
+		if !holdsFile(fset, p, entry) {
+			continue
+		}
+		if strings.HasSuffix(p.ID, ".test]") == tests {
+			return p
+		}
+		if pkg == nil {
+			pkg = p
 		}
 	}
-	if entryAST == nil {
-		return nil, nil, fmt.Errorf("unable to find the entrance AST")
+	return pkg
+}
+
+// generatedRoots returns the top-level declarations of the generated files
+// of pkg.
+func generatedRoots(pkg *packages.Package) []ast.Node {
+	var roots []ast.Node
+	for _, f := range pkg.Syntax {
+		if ast.IsGenerated(f) {
+			roots = append(roots, rootNodes(f.Decls, nil)...)
+		}
+	}
+	return roots
+}
+
+// holdsFile reports whether filename is among the parsed files of pkg.
+func holdsFile(fset *token.FileSet, pkg *packages.Package, filename string) bool {
+	for _, f := range pkg.Syntax {
+		if fset.File(f.Pos()).Name() == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// reach holds the outcome of a traversal: the objects reached and the
+// declarations and specs kept for them.
+type reach struct {
+	used      map[string]bool
+	keptDecls map[ast.Decl]bool
+	keptSpecs map[ast.Spec]bool
+	// keptNames holds the kept names of value specs declaring several, each
+	// with its own value.
+	keptNames map[*ast.Ident]bool
+	graph     *Graph
+	// stubs reports whether the body of a kept function is replaced by a
+	// stub.
+	stubs func(fn *ast.FuncDecl) bool
+}
+
+// collect walks the declarations of pkgs reachable from roots, top-level
+// functions and specs of their files. Objects are looked up in the package
+// declaring them. Methods are matched by name within their package, and in
+// all of pkgs for interface methods. If found is not nil, it is called with
+// each declaration as it is kept and the object it declares; the traversal
+// stops early once found returns false. The declaration indexes of pkgs are
+// taken from cache, and added to it, if it is not nil.
+func (a *Analyzer) collect(fset *token.FileSet, pkgs []*packages.Package, roots []ast.Node, cache map[*types.Package]*declIndex, found func(types.Object, ast.Decl) bool) *reach {
+	indexes := map[*types.Package]*declIndex{}
+	infos := map[*token.File]*types.Info{}
+	for _, pkg := range pkgs {
+		index := cache[pkg.Types]
+		if index == nil {
+			index = newDeclIndex(pkg.Syntax, pkg.TypesInfo)
+			if cache != nil {
+				cache[pkg.Types] = index
+			}
+		}
+		indexes[pkg.Types] = index
+		for _, f := range pkg.Syntax {
+			infos[fset.File(f.Pos())] = pkg.TypesInfo
+		}
+	}
+	infoOf := func(node ast.Node) *types.Info { return infos[fset.File(node.Pos())] }
+	// searched holds the packages whose methods of a name were looked up
+	// already, since every method of that name in a package is kept at once.
+	type methodKey struct {
+		pkg  *types.Package
+		name string
+	}
+	searched := map[methodKey]bool{}
+	lookup := func(obj types.Object) []declRef {
+		if fn, ok := obj.(*types.Func); ok && fn.Type().(*types.Signature).Recv() != nil {
+			// A call through an interface may reach implementations in any
+			// package, a concrete method only those of its own.
+			candidates := []*types.Package{fn.Pkg()}
+			if types.IsInterface(fn.Type().(*types.Signature).Recv().Type()) {
+				candidates = candidates[:0]
+				for _, pkg := range pkgs {
+					candidates = append(candidates, pkg.Types)
+				}
+			}
+			var refs []declRef
+			for _, pkg := range candidates {
+				key := methodKey{pkg, fn.Name()}
+				if index := indexes[pkg]; index != nil && !searched[key] {
+					searched[key] = true
+					refs = append(refs, index.lookup(obj)...)
+				}
+			}
+			return refs
+		}
+		if index := indexes[obj.Pkg()]; index != nil {
+			return index.lookup(obj)
+		}
+		return nil
 	}
 
 	visited := map[types.Object]bool{}
-	used := map[string]bool{}
-	declMap := map[string]ast.Decl{}
+	r := &reach{used: map[string]bool{}, keptDecls: map[ast.Decl]bool{}, keptSpecs: map[ast.Spec]bool{}, keptNames: map[*ast.Ident]bool{}, graph: newGraph()}
+	r.stubs = func(fn *ast.FuncDecl) bool {
+		return a.StubBodies && fn.Body != nil && fn.Name.IsExported()
+	}
+	used, keptDecls, keptSpecs, graph := r.used, r.keptDecls, r.keptSpecs, r.graph
+
+	// current is the object whose declaration is being walked.
+	var current types.Object
+	// stopped is set once found asks to stop, which cuts the traversal short.
+	stopped := false
+	discover := func(obj types.Object, decl ast.Decl) {
+		if found != nil && !stopped && !found(obj, decl) {
+			stopped = true
+		}
+	}
 
 	var visit func(obj types.Object)
 	visit = func(obj types.Object) {
-		if obj == nil || visited[obj] {
+		// Package names only qualify what is kept; their imports are
+		// derived from the kept declarations.
+		if _, ok := obj.(*types.PkgName); ok || obj == nil || stopped {
+			return
+		}
+		graph.addEdge(current, obj)
+		if visited[obj] {
 			return
 		}
 		visited[obj] = true
+		prev := current
+		current = obj
+		defer func() { current = prev }()
+		if a.KeepFunc != nil {
+			if _, drop := a.KeepFunc(obj); drop {
+				return
+			}
+		}
 		used[obj.Name()] = true
 
-		for _, file := range files {
-			for _, decl := range file.Decls {
-				switch d := decl.(type) {
-				case *ast.FuncDecl:
-					if d.Name.Name == obj.Name() {
-						declMap[obj.Name()] = d
-						if d.Body != nil {
-							ast.Inspect(d.Body, func(n ast.Node) bool {
-								if id, ok := n.(*ast.Ident); ok {
-									ref := info.Uses[id]
-									if ref == nil {
-										ref = info.Defs[id]
-									}
-									visit(ref)
-								}
-								return true
-							})
-						}
+		for _, ref := range lookup(obj) {
+			info := infoOf(ref.decl)
+			switch d := ref.decl.(type) {
+			case *ast.FuncDecl:
+				// Methods are looked up by name and may be reached repeatedly.
+				if keptDecls[d] {
+					continue
+				}
+				keptDecls[d] = true
+				discover(info.Defs[d.Name], d)
+				visitFieldList(d.Recv, info, visit)
+				visitTypeExpr(d.Type, info, visit)
+				if d.Body != nil && !r.stubs(d) {
+					visitIdents(d.Body, info, visit)
+				}
+			case *ast.GenDecl:
+				keptDecls[d] = true
+				if !keptSpecs[ref.spec] {
+					keptSpecs[ref.spec] = true
+					discover(obj, d)
+				}
+				switch s := ref.spec.(type) {
+				case *ast.TypeSpec:
+					visitFieldList(s.TypeParams, info, visit)
+					visitTypeExpr(s.Type, info, visit)
+				case *ast.ValueSpec:
+					if s.Type != nil {
+						visitTypeExpr(s.Type, info, visit)
 					}
-				case *ast.GenDecl:
-					for _, spec := range d.Specs {
-						switch s := spec.(type) {
-						case *ast.TypeSpec:
-							if s.Name.Name == obj.Name() {
-								declMap[obj.Name()] = d
-								if structType, ok := s.Type.(*ast.StructType); ok {
-									for _, field := range structType.Fields.List {
-										visitTypeExpr(field.Type, info, visit)
-									}
-								}
+					values := s.Values
+					if len(s.Names) > 1 && len(s.Values) == len(s.Names) {
+						// Only the value of the name reached is needed.
+						for i, name := range s.Names {
+							if info.Defs[name] == obj {
+								values = s.Values[i : i+1]
+								r.keptNames[name] = true
 							}
-						case *ast.ValueSpec:
-							for _, name := range s.Names {
-								if name.Name == obj.Name() {
-									declMap[obj.Name()] = d
-									if s.Type != nil {
-										visitTypeExpr(s.Type, info, visit)
-									}
-									for _, val := range s.Values {
-										visitExpr(val, info, visit)
-									}
-								}
+						}
+					}
+					for _, val := range values {
+						visitExpr(val, info, visit)
+					}
+					if isIotaGroup(d) {
+						// The whole group is emitted, so its other members must be reachable too.
+						for _, other := range d.Specs {
+							for _, n := range other.(*ast.ValueSpec).Names {
+								visit(info.Defs[n])
 							}
 						}
 					}
@@ -137,25 +1141,411 @@ func CollectUsedDeclarations(entryFile string) (map[string]bool, []ast.Decl, err
 		}
 	}
 
-	for _, decl := range entryAST.Decls {
-		ast.Inspect(decl, func(n ast.Node) bool {
-			if id, ok := n.(*ast.Ident); ok {
-				obj := info.Uses[id]
-				if obj == nil {
-					obj = info.Defs[id]
+	// seed walks node, declaring root, as a root of the traversal.
+	seed := func(root types.Object, node ast.Node) {
+		graph.addRoot(root)
+		if fn, ok := node.(*ast.FuncDecl); ok && r.stubs(fn) {
+			// Only the signature of a stubbed function is walked.
+			visit(root)
+			return
+		}
+		current = root
+		visitIdents(node, infoOf(node), visit)
+		current = nil
+	}
+	for _, node := range roots {
+		seed(nodeObject(node, infoOf(node)), node)
+	}
+	if a.KeepFunc != nil {
+		for _, pkg := range pkgs {
+			for _, obj := range packageObjects(pkg.Types) {
+				if force, _ := a.KeepFunc(obj); force {
+					graph.addRoot(obj)
+					visit(obj)
 				}
-				visit(obj)
 			}
-			return true
-		})
+		}
 	}
 
+	// Some declarations are kept only because of what else is kept, and
+	// may in turn keep more: repeat until nothing is added.
+	var assertions []assertion
+	for _, pkg := range pkgs {
+		assertions = append(assertions, indexes[pkg.Types].assertions(pkg.TypesInfo)...)
+	}
+	for added := true; added && !stopped; {
+		added = false
+		for _, as := range assertions {
+			if concrete := namedTypeName(as.concrete); !visited[as.obj] && visited[concrete] {
+				current = concrete
+				visit(as.obj)
+				for _, m := range as.methods() {
+					visit(m)
+				}
+				current = nil
+				added = true
+			}
+		}
+		if a.KeepAllMethods || a.ExportedRoots {
+			for obj := range visited {
+				tn, ok := obj.(*types.TypeName)
+				if !ok || indexes[tn.Pkg()] == nil || !a.KeepAllMethods && !tn.Exported() {
+					continue
+				}
+				if named, ok := tn.Type().(*types.Named); ok {
+					for i := 0; i < named.NumMethods(); i++ {
+						if m := named.Method(i); !visited[m] && (a.KeepAllMethods || m.Exported()) {
+							current = tn
+							visit(m)
+							current = nil
+							added = true
+						}
+					}
+				}
+			}
+		}
+		if a.KeepTagged != "" {
+			for obj := range visited {
+				tn, ok := obj.(*types.TypeName)
+				if !ok || indexes[tn.Pkg()] == nil {
+					continue
+				}
+				for _, t := range taggedTypes(tn, a.KeepTagged) {
+					if indexes[t.Obj().Pkg()] == nil {
+						continue
+					}
+					for i := 0; i < t.NumMethods(); i++ {
+						if m := t.Method(i); !visited[m] {
+							current = tn
+							visit(m)
+							current = nil
+							added = true
+						}
+					}
+				}
+			}
+		}
+		for _, pkg := range pkgs {
+			info := pkg.TypesInfo
+			// Variables may be set up by init functions, such as a recursive
+			// closure that must refer to itself.
+			for _, fn := range indexes[pkg.Types].inits {
+				if obj := info.Defs[fn.Name]; !visited[obj] && referencesAny(fn.Body, info, pkg.Types, visited) {
+					graph.addRoot(obj)
+					visit(obj)
+					added = true
+				}
+			}
+			if a.KeepExamples {
+				kept := keptNames(keptDecls, keptSpecs)
+				for _, ex := range exampleFuncs(fset, pkg.Syntax) {
+					if !keptDecls[ex] && kept[exampleTarget(ex.Name.Name)] {
+						graph.addRoot(info.Defs[ex.Name])
+						visit(info.Defs[ex.Name])
+						added = true
+					}
+				}
+			}
+		}
+	}
+	return r
+}
+
+// decls returns the kept declarations of files in source order, with
+// grouped declarations trimmed to their kept specs, specs declaring several
+// values to their kept names, and stubbed functions given their stub body.
+func (r *reach) decls(fset *token.FileSet, files []*ast.File) []ast.Decl {
 	var decls []ast.Decl
-	for _, d := range declMap {
-		decls = append(decls, d)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if !r.keptDecls[decl] {
+				continue
+			}
+			if gd, ok := decl.(*ast.GenDecl); ok && !isIotaGroup(gd) {
+				filtered := filterSpecs(gd, r.keptSpecs)
+				for i, spec := range filtered.Specs {
+					if vs, ok := spec.(*ast.ValueSpec); ok {
+						filtered.Specs[i] = trimNames(vs, r.keptNames)
+					}
+				}
+				decl = filtered
+			}
+			if fn, ok := decl.(*ast.FuncDecl); ok && r.stubs(fn) {
+				decl = stubBody(fset, fn)
+			} else if ok && fn.Body == nil {
+				slog.Warn("Function has no Go body, its assembly or linkname implementation must be provided separately",
+					"func", fn.Name.Name, "pos", fset.Position(fn.Pos()).String())
+			}
+			decls = append(decls, decl)
+		}
 	}
+	return decls
+}
 
-	return used, decls, nil
+// entryPath returns the absolute path of the entry file named file,
+// resolving a relative path against ModuleRoot if set.
+func (a *Analyzer) entryPath(file string) (string, error) {
+	if a.ModuleRoot != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(a.ModuleRoot, file)
+	}
+	return filepath.Abs(file)
+}
+
+// env returns the environment the go command is run with.
+func (a *Analyzer) env() ([]string, error) {
+	env := os.Environ()
+	if a.GOOS != "" {
+		env = append(env, "GOOS="+a.GOOS)
+	}
+	if a.Workspace == "" {
+		return env, nil
+	}
+	work, err := filepath.Abs(a.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(work); err != nil {
+		return nil, fmt.Errorf("invalid workspace: %w", err)
+	} else if fi.IsDir() {
+		work = filepath.Join(work, "go.work")
+	}
+	// Workspace mode rejects -mod=mod, which some environments set globally.
+	var flags []string
+	for _, f := range strings.Fields(os.Getenv("GOFLAGS")) {
+		if !strings.HasPrefix(f, "-mod=") {
+			flags = append(flags, f)
+		}
+	}
+	return append(env, "GOWORK="+work, "GOFLAGS="+strings.Join(flags, " ")), nil
+}
+
+// batchOutput returns the output path of the cut of entryFile in an
+// -input-glob run: its path relative to the working directory under
+// outputDir, or its base name for files outside of the working directory.
+func batchOutput(outputDir, entryFile string) (string, error) {
+	abs, err := filepath.Abs(entryFile)
+	if err != nil {
+		return "", err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(abs)
+	}
+	return filepath.Join(outputDir, rel), nil
+}
+
+// matrixOutput returns the output path of the cut for goos in a
+// -goos-matrix run: outPath with _goos inserted before the extension.
+func matrixOutput(outPath, goos string) string {
+	ext := filepath.Ext(outPath)
+	return strings.TrimSuffix(outPath, ext) + "_" + goos + ext
+}
+
+// packageObjects returns the package-level objects of pkg and the methods
+// of its named types.
+func packageObjects(pkg *types.Package) []types.Object {
+	var objs []types.Object
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		objs = append(objs, obj)
+		if named, ok := obj.Type().(*types.Named); ok && !obj.(*types.TypeName).IsAlias() {
+			for i := 0; i < named.NumMethods(); i++ {
+				objs = append(objs, named.Method(i))
+			}
+		}
+	}
+	return objs
+}
+
+// hasRootDecls reports whether f declares anything besides imports.
+func hasRootDecls(f *ast.File) bool {
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// isIotaGroup reports whether d is a const block whose values depend on the
+// position of its members (iota or implicitly repeated expressions). Such a
+// block must be emitted whole to keep its values intact.
+func isIotaGroup(d *ast.GenDecl) bool {
+	if d.Tok != token.CONST {
+		return false
+	}
+	for _, spec := range d.Specs {
+		s := spec.(*ast.ValueSpec)
+		if len(s.Values) == 0 {
+			return true
+		}
+		for _, val := range s.Values {
+			usesIota := false
+			ast.Inspect(val, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+					usesIota = true
+				}
+				return !usesIota
+			})
+			if usesIota {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stubBody returns a copy of fn whose body only panics.
+func stubBody(fset *token.FileSet, fn *ast.FuncDecl) *ast.FuncDecl {
+	panicCall := &ast.CallExpr{
+		Fun:  ast.NewIdent("panic"),
+		Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("not implemented")}},
+	}
+	body := &ast.BlockStmt{Lbrace: fn.Body.Lbrace, List: []ast.Stmt{&ast.ExprStmt{X: panicCall}}, Rbrace: fn.Body.Rbrace}
+	// Put the stub and the closing brace on the line after the opening
+	// one, so the lines of the old body don't turn into blank lines.
+	tf := fset.File(fn.Pos())
+	if line := tf.PositionFor(body.Lbrace, false).Line; line < tf.LineCount() {
+		next := tf.LineStart(line + 1)
+		panicCall.Fun.(*ast.Ident).NamePos = next
+		body.Rbrace = next
+	}
+	stub := *fn
+	stub.Body = body
+	return &stub
+}
+
+// trimNames returns a copy of s declaring only the names in keep, with their
+// values, or s itself if it keeps all or none of them.
+func trimNames(s *ast.ValueSpec, keep map[*ast.Ident]bool) *ast.ValueSpec {
+	trimmed := *s
+	trimmed.Names, trimmed.Values = nil, nil
+	for i, name := range s.Names {
+		if keep[name] {
+			trimmed.Names = append(trimmed.Names, name)
+			trimmed.Values = append(trimmed.Values, s.Values[i])
+		}
+	}
+	if len(trimmed.Names) == 0 || len(trimmed.Names) == len(s.Names) {
+		return s
+	}
+	return &trimmed
+}
+
+// specKeys returns the positions identifying spec, which a copy trimmed to
+// some of its names still shares at least one of: the positions of the names
+// of a value spec, the position of any other spec.
+func specKeys(spec ast.Spec) []token.Pos {
+	if vs, ok := spec.(*ast.ValueSpec); ok {
+		keys := make([]token.Pos, len(vs.Names))
+		for i, name := range vs.Names {
+			keys[i] = name.Pos()
+		}
+		return keys
+	}
+	return []token.Pos{spec.Pos()}
+}
+
+// filterSpecs returns a copy of d holding only the specs in keep.
+func filterSpecs(d *ast.GenDecl, keep map[ast.Spec]bool) *ast.GenDecl {
+	filtered := *d
+	filtered.Specs = nil
+	for _, spec := range d.Specs {
+		if keep[spec] {
+			filtered.Specs = append(filtered.Specs, spec)
+		}
+	}
+	// Close the group right after its last kept spec, so the lines of the
+	// dropped specs that followed don't turn into blank lines.
+	if n := len(filtered.Specs); n > 0 && d.Rparen.IsValid() && filtered.Specs[n-1] != d.Specs[len(d.Specs)-1] {
+		_, filtered.Rparen = specRange(filtered.Specs[n-1])
+	}
+	return &filtered
+}
+
+// visitIdents visits the object of every identifier under node. Selectors
+// additionally visit the named type they select from, so intermediate types
+// of chains like x.Field.Method() are kept along with the selected member.
+func visitIdents(node ast.Node, info *types.Info, visit func(types.Object)) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			obj := info.Uses[n]
+			if obj == nil {
+				obj = info.Defs[n]
+			}
+			visit(obj)
+		case *ast.SelectorExpr:
+			if sel := info.Selections[n]; sel != nil {
+				visit(namedTypeName(sel.Recv()))
+				visit(sel.Obj())
+			}
+		}
+		return true
+	})
+}
+
+// taggedTypes returns, if tn is a struct with a field tagged with key, the
+// struct and the named types of its fields, seen through pointers, slices
+// and arrays.
+func taggedTypes(tn *types.TypeName, key string) []*types.Named {
+	named, ok := tn.Type().(*types.Named)
+	if !ok {
+		return nil
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	tagged := false
+	for i := 0; i < st.NumFields(); i++ {
+		if _, ok := reflect.StructTag(st.Tag(i)).Lookup(key); ok {
+			tagged = true
+		}
+	}
+	if !tagged {
+		return nil
+	}
+	result := []*types.Named{named}
+	for i := 0; i < st.NumFields(); i++ {
+		t := st.Field(i).Type()
+		for {
+			switch e := t.(type) {
+			case *types.Pointer:
+				t = e.Elem()
+				continue
+			case *types.Slice:
+				t = e.Elem()
+				continue
+			case *types.Array:
+				t = e.Elem()
+				continue
+			}
+			break
+		}
+		if n, ok := t.(*types.Named); ok {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// namedTypeName returns the declaration of the named type t, or *t, refers
+// to, or nil if t is not a named type.
+func namedTypeName(t types.Type) types.Object {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Origin().Obj()
+	}
+	return nil
 }
 
 func visitTypeExpr(expr ast.Expr, info *types.Info, visit func(types.Object)) {
@@ -164,27 +1554,67 @@ func visitTypeExpr(expr ast.Expr, info *types.Info, visit func(types.Object)) {
 		visit(info.Uses[e])
 	case *ast.StarExpr:
 		visitTypeExpr(e.X, info, visit)
+	case *ast.ParenExpr:
+		visitTypeExpr(e.X, info, visit)
 	case *ast.ArrayType:
+		if e.Len != nil {
+			visitExpr(e.Len, info, visit)
+		}
 		visitTypeExpr(e.Elt, info, visit)
 	case *ast.MapType:
 		visitTypeExpr(e.Key, info, visit)
 		visitTypeExpr(e.Value, info, visit)
 	case *ast.SelectorExpr:
-
+		// A qualified type: pkg.T.
 		visitTypeExpr(e.X, info, visit)
+		visit(info.Uses[e.Sel])
 	case *ast.FuncType:
-		for _, field := range e.Params.List {
-			visitTypeExpr(field.Type, info, visit)
-		}
-		for _, field := range e.Results.List {
-			visitTypeExpr(field.Type, info, visit)
-		}
+		visitFieldList(e.TypeParams, info, visit)
+		visitFieldList(e.Params, info, visit)
+		visitFieldList(e.Results, info, visit)
+	case *ast.StructType:
+		visitFieldList(e.Fields, info, visit)
 	case *ast.InterfaceType:
-
+		// Methods, embedded interfaces and constraint type terms.
+		visitFieldList(e.Methods, info, visit)
+	case *ast.BinaryExpr:
+		// Union of constraint type terms: A | B.
+		visitTypeExpr(e.X, info, visit)
+		visitTypeExpr(e.Y, info, visit)
+	case *ast.UnaryExpr:
+		// Underlying type term: ~T.
+		visitTypeExpr(e.X, info, visit)
 	case *ast.ChanType:
 		visitTypeExpr(e.Value, info, visit)
 	case *ast.Ellipsis:
 		visitTypeExpr(e.Elt, info, visit)
+	case *ast.IndexExpr:
+		visitTypeExpr(e.X, info, visit)
+		visitTypeExpr(e.Index, info, visit)
+	case *ast.IndexListExpr:
+		visitTypeExpr(e.X, info, visit)
+		for _, index := range e.Indices {
+			visitTypeExpr(index, info, visit)
+		}
+	}
+}
+
+// visitFieldList visits the types of the fields in list, which may be nil.
+func visitFieldList(list *ast.FieldList, info *types.Info, visit func(types.Object)) {
+	if list == nil {
+		return
+	}
+	for _, field := range list.List {
+		visitTypeExpr(field.Type, info, visit)
+	}
+}
+
+// visitIndex visits index, an index expression or a type argument.
+func visitIndex(index ast.Expr, info *types.Info, visit func(types.Object)) {
+	if tv, ok := info.Types[index]; ok && tv.IsType() {
+		visitTypeExpr(index, info, visit)
+	} else {
+		visitExpr(index, info, visit)
 	}
 }
 
@@ -207,16 +1637,26 @@ func visitExpr(expr ast.Expr, info *types.Info, visit func(types.Object)) {
 		visitExpr(e.Y, info, visit)
 	case *ast.ParenExpr:
 		visitExpr(e.X, info, visit)
+	case *ast.StarExpr:
+		visitExpr(e.X, info, visit)
 	case *ast.KeyValueExpr:
 		visitExpr(e.Key, info, visit)
 		visitExpr(e.Value, info, visit)
 	case *ast.Ident:
 		visit(info.Uses[e])
 	case *ast.SelectorExpr:
+		// A qualified identifier, a field or a method.
 		visitExpr(e.X, info, visit)
+		visit(info.Uses[e.Sel])
 	case *ast.IndexExpr:
+		// An index, or the type argument of a generic function: F[T].
+		visitExpr(e.X, info, visit)
+		visitIndex(e.Index, info, visit)
+	case *ast.IndexListExpr:
 		visitExpr(e.X, info, visit)
-		visitExpr(e.Index, info, visit)
+		for _, index := range e.Indices {
+			visitIndex(index, info, visit)
+		}
 	case *ast.SliceExpr:
 		visitExpr(e.X, info, visit)
 		if e.Low != nil {
@@ -230,46 +1670,286 @@ func visitExpr(expr ast.Expr, info *types.Info, visit func(types.Object)) {
 		}
 	case *ast.TypeAssertExpr:
 		visitExpr(e.X, info, visit)
-	case *ast.BasicLit, *ast.BadExpr, *ast.FuncLit, *ast.Ellipsis:
+		// e.Type is nil in the x.(type) of a type switch.
+		if e.Type != nil {
+			visitTypeExpr(e.Type, info, visit)
+		}
+	case *ast.FuncLit:
+		// Closures such as deferred recovers carry their own dependencies.
+		visitIdents(e, info, visit)
+	case *ast.BasicLit, *ast.BadExpr, *ast.Ellipsis:
 	default:
 	}
 }
 
-func WriteFilteredSource(entryFile, outFile string, decls []ast.Decl) error {
-	src, err := os.ReadFile(entryFile)
+// WriteFilteredSource writes the cut file of res to outFile.
+func WriteFilteredSource(res *Result, outFile string, opts WriteOptions) error {
+	_, err := writeFilteredSource(res, outFile, opts)
+	return err
+}
+
+// writeFilteredSource implements WriteFilteredSource, returning outFile if
+// it was written and nothing if opts had it keep an existing file.
+func writeFilteredSource(res *Result, outFile string, opts WriteOptions) ([]string, error) {
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, opts); err != nil {
+		return nil, err
+	}
+	return writeOutputs([]string{outFile}, [][]byte{buf.Bytes()}, opts)
+}
+
+// WriteFilteredSourceTo writes the cut file of res to w.
+func WriteFilteredSourceTo(w io.Writer, res *Result, opts WriteOptions) error {
+	src, err := renderSource(res, opts)
 	if err != nil {
 		return err
 	}
+	_, err = w.Write(src)
+	return err
+}
 
-	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, entryFile, src, parser.AllErrors)
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a failure never leaves a partial file at path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
 	if err != nil {
 		return err
 	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// keepExisting reports whether the file at path exists and is left as it is
+// under opts.OutputMode, which fails for existing files in "error" mode.
+func (opts WriteOptions) keepExisting(path string) (bool, error) {
+	if opts.OutputMode == "" || opts.OutputMode == "overwrite" {
+		return false, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if opts.OutputMode == "error" {
+		return false, fmt.Errorf("output file %s already exists", path)
+	}
+	return true, nil
+}
 
-	file.Decls = decls
+// writeOutputs writes each of data to the output file at the same index of
+// paths, unless opts has it keep an existing one, and returns the paths
+// written. All of paths are checked first, so that nothing is written if
+// one of them fails in "error" mode.
+func writeOutputs(paths []string, data [][]byte, opts WriteOptions) ([]string, error) {
+	keep := make([]bool, len(paths))
+	for i, path := range paths {
+		var err error
+		if keep[i], err = opts.keepExisting(path); err != nil {
+			return nil, err
+		}
+	}
+	var written []string
+	for i, path := range paths {
+		if keep[i] {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return written, err
+		}
+		if err := writeFileAtomic(path, data[i]); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
 
-	if err := os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
-		return err
+// WriteMirroredSource writes one file to outDir for each source file of the
+// analyzed package, holding the kept declarations of that file, and returns
+// the paths written. With opts.PruneEmptyFiles, files left without
+// declarations are skipped, unless the source file held nothing but its
+// build constraints to begin with.
+func WriteMirroredSource(res *Result, outDir string, opts WriteOptions) ([]string, error) {
+	paths, data, err := mirroredOutputs(res, outDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return writeOutputs(paths, data, opts)
+}
+
+// mirroredOutputs returns the paths of the files WriteMirroredSource writes
+// to outDir, in the order of the source files, with their content.
+func mirroredOutputs(res *Result, outDir string, opts WriteOptions) ([]string, [][]byte, error) {
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, opts); err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	var data [][]byte
+	for _, file := range res.Pkg.Syntax {
+		name := filepath.Base(res.Fset.File(file.Pos()).Name())
+		if buf, ok := files[name]; ok {
+			paths = append(paths, filepath.Join(outDir, name))
+			data = append(data, buf.Bytes())
+		}
+	}
+	return paths, data, nil
+}
+
+// WriteMirroredSourceTo renders the files WriteMirroredSource writes into
+// files, keyed by their base name.
+func WriteMirroredSourceTo(files map[string]*bytes.Buffer, res *Result, opts WriteOptions) error {
+	var names []string
+	var srcs [][]byte
+	for _, file := range res.Pkg.Syntax {
+		name := res.Fset.File(file.Pos()).Name()
+		var decls []ast.Decl
+		for _, decl := range res.Decls {
+			if res.Fset.File(decl.Pos()).Name() == name {
+				decls = append(decls, decl)
+			}
+		}
+		if opts.PruneEmptyFiles && len(decls) == 0 && !constraintOnly(file) {
+			continue
+		}
+		src, err := renderFile(res, file, decls, opts)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.Base(name))
+		srcs = append(srcs, src)
+	}
+	if opts.ConsistentImports {
+		var err error
+		if srcs, err = unifyImportNames(srcs, importedNames(res), opts); err != nil {
+			return err
+		}
+	}
+	for i, name := range names {
+		files[name] = bytes.NewBuffer(srcs[i])
+	}
+	return nil
+}
+
+// importedNames maps the paths of the packages imported by the analyzed
+// package to their names.
+func importedNames(res *Result) map[string]string {
+	names := map[string]string{}
+	for _, pkg := range res.Pkg.Types.Imports() {
+		names[pkg.Path()] = pkg.Name()
+	}
+	return names
+}
+
+// WriteSplitSource distributes the kept declarations of res over n files of
+// the same package and returns the paths written. The files are named after
+// outFile with _1, _2, ... inserted before the extension. Declarations keep
+// their order, so methods mostly stay next to their types; the files share
+// the package scope and compile together however the declarations fall.
+func WriteSplitSource(res *Result, outFile string, n int, opts WriteOptions) ([]string, error) {
+	n = max(1, min(n, len(res.Decls)))
+	srcs := make([][]byte, n)
+	for i := range n {
+		decls := res.Decls[i*len(res.Decls)/n : (i+1)*len(res.Decls)/n]
+		var err error
+		if srcs[i], err = renderFile(res, res.Entry, decls, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ConsistentImports {
+		var err error
+		if srcs, err = unifyImportNames(srcs, importedNames(res), opts); err != nil {
+			return nil, err
+		}
+	}
+	ext := filepath.Ext(outFile)
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(outFile, ext), i+1, ext)
 	}
-	fout, err := os.Create(outFile)
+	return writeOutputs(paths, srcs, opts)
+}
+
+// BuildOverlay returns the cut of res as a packages.Config.Overlay: the
+// content each source file of the package is left with, keyed by its path.
+// Loading the package with it sees the cut in place of the sources.
+func BuildOverlay(res *Result) (map[string][]byte, error) {
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, WriteOptions{}); err != nil {
+		return nil, err
+	}
+	overlay := map[string][]byte{}
+	for _, file := range res.Pkg.Syntax {
+		name := res.Fset.File(file.Pos()).Name()
+		overlay[name] = files[filepath.Base(name)].Bytes()
+	}
+	return overlay, nil
+}
+
+// constraintOnly reports whether file declares nothing and only contributes
+// its build constraints to the package.
+func constraintOnly(file *ast.File) bool {
+	for _, decl := range file.Decls {
+		if gd, ok := decl.(*ast.GenDecl); !ok || gd.Tok != token.IMPORT {
+			return false
+		}
+	}
+	for _, cg := range file.Comments {
+		if cg.End() >= file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runAfter runs command, split into fields with {} in each replaced by
+// path. Its output is returned with the error if it fails.
+func runAfter(command, path string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "{}", path)
+	}
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %w: %s", args[0], err, bytes.TrimSpace(out))
 	}
-	defer fout.Close()
-	return format.Node(fout, fset, file)
+	return nil
 }
 
-func autoFixImports(filePath string) error {
+func autoFixImports(filePath string, opts WriteOptions) error {
 	src, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
 
+	// goimports only reads the grouping prefix from this package variable.
+	imports.LocalPrefix = opts.LocalPrefix
+
+	cfg := opts.printerConfig()
 	opt := &imports.Options{
 		Comments:   true,
-		TabWidth:   8,
-		TabIndent:  true,
+		TabWidth:   cfg.Tabwidth,
+		TabIndent:  cfg.Mode&printer.TabIndent != 0,
 		FormatOnly: false,
 	}
 
@@ -277,6 +1957,15 @@ func autoFixImports(filePath string) error {
 	if err != nil {
 		return err
 	}
+	if opts.NormalizeWhitespace || opts.TrimBlankLines {
+		if fixed, err = opts.format(fixed); err != nil {
+			return err
+		}
+	}
+	// goimports formats its result with gofmt, whatever the options say.
+	if fixed, err = reindent(fixed, opts); err != nil {
+		return err
+	}
 
-	return os.WriteFile(filePath, fixed, 0644)
+	return writeFileAtomic(filePath, fixed)
 }
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"log"
+	"log/slog"
+	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+
+	"golang.org/x/tools/go/packages"
 )
 
 func TestCollectUsedDeclarations(t *testing.T) {
@@ -59,12 +72,12 @@ func TestWriteFilteredSource(t *testing.T) {
 	}
 	out := filepath.Join(t.TempDir(), "out.go")
 
-	_, decls, err := CollectUsedDeclarations(absEntry)
+	res, err := (&Analyzer{}).Analyze(absEntry)
 	if err != nil {
-		t.Fatalf("CollectUsedDeclarations failed: %v", err)
+		t.Fatalf("Analyze failed: %v", err)
 	}
 
-	if err := WriteFilteredSource(entry, out, decls); err != nil {
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
 		t.Fatalf("WriteFilteredSource failed: %v", err)
 	}
 
@@ -86,16 +99,16 @@ func TestAutoFixImports(t *testing.T) {
 	}
 	out := filepath.Join(t.TempDir(), "out.go")
 
-	_, decls, err := CollectUsedDeclarations(absEntry)
+	res, err := (&Analyzer{}).Analyze(absEntry)
 	if err != nil {
-		t.Fatalf("CollectUsedDeclarations failed: %v", err)
+		t.Fatalf("Analyze failed: %v", err)
 	}
 
-	if err := WriteFilteredSource(entry, out, decls); err != nil {
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
 		t.Fatalf("WriteFilteredSource failed: %v", err)
 	}
 
-	if err := autoFixImports(out); err != nil {
+	if err := autoFixImports(out, WriteOptions{}); err != nil {
 		t.Fatalf("autoFixImports failed: %v", err)
 	}
 
@@ -109,3 +122,2695 @@ func TestAutoFixImports(t *testing.T) {
 		t.Errorf("expected imports to be fixed, but got none")
 	}
 }
+
+func TestWriteFilteredSourceUseSpaces(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	opts := WriteOptions{TabWidth: 4, UseSpaces: true}
+	if err := WriteFilteredSource(res, out, opts); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, opts); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	if strings.Contains(string(content), "\t") {
+		t.Errorf("expected no tabs in the output, got:\n%s", content)
+	}
+	if !strings.Contains(string(content), "\n    helper()\n") {
+		t.Errorf("expected statements indented by 4 spaces, got:\n%s", content)
+	}
+}
+
+// writeTree writes files, keyed by slash-separated relative path, into a
+// fresh temporary directory and returns it. It is used for fixtures that
+// cannot live in the module, such as separate modules or files that only
+// build under certain conditions.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// declaredNames returns the names of all functions, types, consts and vars
+// declared by decls.
+func declaredNames(decls []ast.Decl) map[string]bool {
+	names := map[string]bool{}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			names[d.Name.Name] = true
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names[s.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names[name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestCollectGroupedConstsWithoutIota(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "consts", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	if !names["Beta"] {
+		t.Errorf("expected referenced const Beta to be kept")
+	}
+	for _, sym := range []string{"Alpha", "Gamma"} {
+		if names[sym] {
+			t.Errorf("expected unreferenced const %s to be dropped", sym)
+		}
+	}
+}
+
+func TestCollectWarnsOnEmptyEntry(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "empty", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decls) != 0 {
+		t.Errorf("expected nothing reachable, got %d decls", len(decls))
+	}
+	if !strings.Contains(buf.String(), "nothing is reachable") {
+		t.Errorf("expected a warning about the empty root set, got %q", buf.String())
+	}
+}
+
+func TestCollectWithWorkspace(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"work/go.work": "go 1.23\n\nuse (\n\t../app\n\t../lib\n)\n",
+		"app/go.mod":   "module example.com/app\n\ngo 1.23\n",
+		"app/entry.go": "package app\n\nimport \"example.com/lib\"\n\nfunc Run() string {\n\treturn lib.Hello()\n}\n",
+		"lib/go.mod":   "module example.com/lib\n\ngo 1.23\n",
+		"lib/lib.go":   "package lib\n\nfunc Hello() string {\n\treturn \"hello\"\n}\n",
+	})
+
+	analyzer := &Analyzer{Workspace: filepath.Join(root, "work")}
+	res, err := analyzer.Analyze(filepath.Join(root, "app", "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Used["Hello"] {
+		t.Errorf("expected Hello from the sibling module to be resolved through the workspace")
+	}
+}
+
+func TestCollectDeferredRecover(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "recovers", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Guarded", "PanicError"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+}
+
+func TestWriteFilteredSourceKeepsDirectives(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "directives", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	src := string(content)
+	directive := strings.Index(src, "//go:generate stringer -type=Color")
+	doc := strings.Index(src, "// Color is a palette entry.")
+	typ := strings.Index(src, "type Color int")
+	if directive < 0 || doc < 0 || typ < 0 {
+		t.Fatalf("expected directive, doc comment and type in output:\n%s", src)
+	}
+	if !(directive < doc && doc < typ) {
+		t.Errorf("expected directive and doc comment above the type:\n%s", src)
+	}
+	if strings.Contains(src, "unused") {
+		t.Errorf("expected unused function to be dropped:\n%s", src)
+	}
+}
+
+func TestWriteFilteredSourceTestdata(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var outputs [][]byte
+	for i := 0; i < 2; i++ {
+		res, err := (&Analyzer{}).Analyze(absEntry)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		out := filepath.Join(t.TempDir(), "out.go")
+		if err := WriteFilteredSource(res, out, WriteOptions{Generated: true}); err != nil {
+			t.Fatalf("WriteFilteredSource failed: %v", err)
+		}
+		if err := autoFixImports(out, WriteOptions{}); err != nil {
+			t.Fatalf("autoFixImports failed: %v", err)
+		}
+		content, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output failed: %v", err)
+		}
+		outputs = append(outputs, content)
+	}
+
+	if !strings.HasPrefix(string(outputs[0]), "// Code generated by gocut; DO NOT EDIT.\n") {
+		t.Errorf("expected generated header, got:\n%s", outputs[0])
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("expected identical output across runs:\n%s\n---\n%s", outputs[0], outputs[1])
+	}
+}
+
+func TestCollectGenericCompositeLiteral(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "generics", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Fruits", "Set", "Name"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+}
+
+func TestCollectConstraintUnion(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "constraints", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Sum", "Number", "MyInt", "MyFloat"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+}
+
+func TestCollectKeepExamples(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "examples", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{KeepExamples: true}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(res.Decls)
+	if !names["ExampleGreet"] {
+		t.Errorf("expected example of kept Greet to be retained")
+	}
+	if names["ExampleFarewell"] || names["Farewell"] {
+		t.Errorf("expected example of dropped Farewell to be dropped")
+	}
+}
+
+func TestMainKeepExamples(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "examples", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+
+	// A single cut file is not a test file, so it cannot hold examples.
+	out := t.TempDir()
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", out, "-keep-examples", "-no-goimports"}
+	if code := run(); code != 1 {
+		t.Errorf("expected -keep-examples without -mirror to be rejected, got exit status %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(out, "entry.go")); !os.IsNotExist(err) {
+		t.Errorf("expected no output written, got %v", err)
+	}
+
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", out, "-keep-examples", "-mirror", "-quiet", "-no-goimports"}
+	if code := run(); code != 0 {
+		t.Fatalf("expected exit status 0, got %d\n%s", code, buf.String())
+	}
+	for name, want := range map[string]bool{"entry.go": false, "greet.go": false, "greet_test.go": true} {
+		src, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(src), "func ExampleGreet()"); got != want {
+			t.Errorf("%s: expected ExampleGreet %v, got:\n%s", name, want, src)
+		}
+	}
+}
+
+func TestExampleTarget(t *testing.T) {
+	tests := map[string]string{
+		"Example":             "",
+		"ExampleGreet":        "Greet",
+		"ExampleGreet_second": "Greet",
+		"ExampleT_Method":     "T.Method",
+		"ExampleT_Method_alt": "T.Method",
+	}
+	for name, want := range tests {
+		if got := exampleTarget(name); got != want {
+			t.Errorf("exampleTarget(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestVisitTypeExprOddFuncTypes(t *testing.T) {
+	exprs := []ast.Expr{
+		&ast.FuncType{},
+		&ast.FuncType{Params: &ast.FieldList{}},
+		&ast.FuncType{Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("int")}}}},
+		&ast.InterfaceType{},
+		&ast.InterfaceType{Methods: &ast.FieldList{List: []*ast.Field{{Type: &ast.FuncType{}}}}},
+		&ast.StructType{},
+		&ast.ArrayType{Len: &ast.Ellipsis{}},
+	}
+	// Function types of interface methods and of function results, as the
+	// parser produces them, with and without parameters and results.
+	for _, src := range []string{"func()", "func(int)", "func() int", "func(...int) (a, b int)", "interface{ M() }", "interface{ M(int); N() int }", "func() func()", "func(func()) func() int"} {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", src, err)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	info := &types.Info{}
+	for _, expr := range exprs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("visitTypeExpr panicked on %T: %v", expr, r)
+				}
+			}()
+			visitTypeExpr(expr, info, func(types.Object) {})
+		}()
+	}
+}
+
+func TestCollectKeepFunc(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "hooks", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	analyzer := &Analyzer{
+		KeepFunc: func(obj types.Object) (force, drop bool) {
+			return obj.Name() == "Extra", obj.Name() == "trace"
+		},
+	}
+	res, err := analyzer.Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(res.Decls)
+	for _, sym := range []string{"Run", "helper", "Extra", "extraDep"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["trace"] {
+		t.Errorf("expected trace to be dropped by the hook")
+	}
+}
+
+func TestCollectChainedSelectors(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "selectors", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Service", "Store", "Get", "suffix"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+}
+
+func TestWriteFilteredSourcePrunesImports(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+
+	// Without goimports the output must already be formatted and import
+	// exactly what the kept declarations use.
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	formatted, err := format.Source(content)
+	if err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+	if !bytes.Equal(formatted, content) {
+		t.Errorf("output is not gofmt-formatted:\n%s", content)
+	}
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, out, content, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("parse imports failed: %v", err)
+	}
+	if len(node.Imports) != 1 || node.Imports[0].Path.Value != `"fmt"` {
+		t.Errorf("expected only the fmt import, got:\n%s", content)
+	}
+}
+
+func TestCollectInterfaceAssertion(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "assertions", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"_", "Shape", "Impl", "Area", "Label"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	for _, sym := range []string{"Closer", "other", "Close"} {
+		if names[sym] {
+			t.Errorf("expected %s to be dropped", sym)
+		}
+	}
+}
+
+func TestCollectRecursiveClosure(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "closures", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"fib", "init", "base"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unrelated"] {
+		t.Errorf("expected the init of an unused variable to be dropped")
+	}
+	// Only the init setting up fib is kept, not the one setting os.Args,
+	// which the entry reads but which belongs to another package.
+	inits := 0
+	for _, decl := range decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "init" {
+			inits++
+		}
+	}
+	if inits != 1 {
+		t.Errorf("expected only the init of fib to be kept, got %d inits", inits)
+	}
+}
+
+func TestCollectTypeSwitchCases(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "typeswitch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"kind", "Circle", "Square"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+}
+
+func TestAutoFixImportsLocalPrefix(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "grouping", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	opts := WriteOptions{LocalPrefix: "github.com/chenhg5/gocut"}
+	if err := WriteFilteredSource(res, out, opts); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, opts); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := `import (
+	"fmt"
+	"go/ast"
+
+	"golang.org/x/tools/go/ast/astutil"
+
+	"github.com/chenhg5/gocut/test/consts"
+)`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected imports grouped with the local prefix last, got:\n%s", content)
+	}
+}
+
+func TestCollectVarsReferencedByMethods(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "methods", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Client", "URL", "config", "apiPath"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unusedConfig"] {
+		t.Errorf("expected unusedConfig to be dropped")
+	}
+}
+
+func TestCollectWithBuildTags(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":    "module example.com/tagged\n\ngo 1.23\n",
+		"entry.go":  "package tagged\n\nfunc Run() string {\n\treturn fixture()\n}\n",
+		"tagged.go": "//go:build integration\n\npackage tagged\n\nfunc fixture() string {\n\treturn \"integration\"\n}\n",
+	})
+
+	res, err := (&Analyzer{Tags: "integration"}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if names := declaredNames(res.Decls); !names["fixture"] {
+		t.Errorf("expected tag-guarded fixture to be kept, got %v", names)
+	}
+}
+
+func TestCollectWarnsOnBodylessFunc(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/asm\n\ngo 1.23\n",
+		"entry.go": "package asm\n\nfunc Run() int {\n\treturn add(1, 2)\n}\n",
+		"add.go":   "package asm\n\n// add is implemented in assembly.\nfunc add(a, b int) int\n",
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	_, decls, err := CollectUsedDeclarations(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !declaredNames(decls)["add"] {
+		t.Errorf("expected bodyless add to be kept")
+	}
+	if !strings.Contains(buf.String(), "func=add") || !strings.Contains(buf.String(), "has no Go body") {
+		t.Errorf("expected a warning about the bodyless function, got %q", buf.String())
+	}
+}
+
+func TestAnalyzeGOOSMatrix(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":         "module example.com/plat\n\ngo 1.23\n",
+		"entry.go":       "package plat\n\nfunc Run() string {\n\treturn name()\n}\n",
+		"name_linux.go":  "package plat\n\nfunc name() string { return linuxName }\n\nconst linuxName = \"linux\"\n",
+		"name_darwin.go": "package plat\n\nfunc name() string { return darwinName }\n\nconst darwinName = \"darwin\"\n",
+	})
+	outDir := t.TempDir()
+	outPath := filepath.Join(outDir, "entry.go")
+
+	want := map[string]string{"linux": "linuxName", "darwin": "darwinName"}
+	for goos := range want {
+		res, err := (&Analyzer{GOOS: goos}).Analyze(filepath.Join(root, "entry.go"))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", goos, err)
+		}
+		if err := WriteFilteredSource(res, matrixOutput(outPath, goos), WriteOptions{}); err != nil {
+			t.Fatalf("%s: write failed: %v", goos, err)
+		}
+		got, err := os.ReadFile(filepath.Join(outDir, "entry_"+goos+".go"))
+		if err != nil {
+			t.Fatalf("%s: reading output failed: %v", goos, err)
+		}
+		for other, otherSymbol := range want {
+			if has := strings.Contains(string(got), otherSymbol); has != (other == goos) {
+				t.Errorf("%s output containing %s = %v\n%s", goos, otherSymbol, has, got)
+			}
+		}
+	}
+}
+
+func TestCollectDefinedFuncTypes(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "functypes", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"HandlerFunc", "Response", "Request", "Header", "Key", "Value", "Events", "Event"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	for _, sym := range []string{"Unused"} {
+		if names[sym] {
+			t.Errorf("expected unused %s to be dropped", sym)
+		}
+	}
+}
+
+func TestResultDeprecated(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "deprecated", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := strings.Join(res.Deprecated(), ",")
+	if want := "Limit,oldSum"; got != want {
+		t.Errorf("deprecated declarations = %s, want %s", got, want)
+	}
+}
+
+func TestCollectRangeOverFunc(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "iterators", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Numbers", "start", "pairs", "weight"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unused"] {
+		t.Errorf("expected unused iterator to be dropped")
+	}
+}
+
+func TestWriteMirroredSourcePruneEmptyFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":      "module example.com/mirror\n\ngo 1.23\n",
+		"entry.go":    "package mirror\n\nfunc Run() int {\n\treturn used()\n}\n",
+		"used.go":     "package mirror\n\nfunc used() int {\n\treturn 1\n}\n",
+		"unused.go":   "package mirror\n\nfunc unused() int {\n\treturn 2\n}\n",
+		"platform.go": "//go:build linux || darwin\n\npackage mirror\n",
+	})
+
+	res, err := (&Analyzer{GOOS: "linux"}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, prune := range []bool{false, true} {
+		outDir := t.TempDir()
+		if _, err := WriteMirroredSource(res, outDir, WriteOptions{PruneEmptyFiles: prune}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		for _, name := range []string{"entry.go", "used.go", "platform.go"} {
+			if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+				t.Errorf("prune=%v: expected %s to be written: %v", prune, name, err)
+			}
+		}
+		_, err := os.Stat(filepath.Join(outDir, "unused.go"))
+		if prune && err == nil {
+			t.Errorf("expected empty unused.go to be pruned")
+		} else if !prune && err != nil {
+			t.Errorf("expected empty unused.go to be written without pruning: %v", err)
+		}
+	}
+}
+
+func TestCollectKeepTagged(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "tagged", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := declNames(res.Decls)
+	want := []string{"Email", "ID", "Load", "Role", "User"}
+	if !slices.Equal(names, want) {
+		t.Errorf("expected %v without -keep-tagged, got %v", want, names)
+	}
+
+	res, err = (&Analyzer{KeepTagged: "db"}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names = declNames(res.Decls)
+	want = []string{"Email", "Email.Value", "ID", "ID.Scan", "Load", "Role", "Role.Scan", "User", "User.TableName"}
+	if !slices.Equal(names, want) {
+		t.Errorf("expected %v with -keep-tagged db, got %v", want, names)
+	}
+}
+
+func TestCollectNestedStructs(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "nested", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// Only Server.Name is accessed, but decoders fill every field.
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Cert", "Config", "Level", "Load", "Mode", "Server", "TLS"}
+	if names := declNames(decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestCollectNamedResults(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "namedresults", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Bounds", "Limit", "Pair", "Parse", "Part", "Split"}
+	if names := declNames(decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestCollectRecursiveTypes(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "recursive", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// Each type is declared once however often it is reached.
+	want := []string{"Branch", "Node", "Tree", "Walk"}
+	if names := declNames(res.Decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestWriteOutputModes(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"", "package patch", false},
+		{"overwrite", "package patch", false},
+		{"skip", "existing", false},
+		{"error", "existing", true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		out := filepath.Join(dir, "entry.go")
+		if err := os.WriteFile(out, []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := WriteFilteredSource(res, out, WriteOptions{OutputMode: tt.mode})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mode %q: expected error %v, got %v", tt.mode, tt.wantErr, err)
+		}
+		if got, _ := os.ReadFile(out); !strings.HasPrefix(string(got), tt.want) {
+			t.Errorf("mode %q: expected the file to start with %q, got:\n%s", tt.mode, tt.want, got)
+		}
+
+		// Mirrored files only report the files written.
+		paths, err := WriteMirroredSource(res, dir, WriteOptions{OutputMode: tt.mode})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("mode %q: expected mirroring error %v, got %v", tt.mode, tt.wantErr, err)
+		}
+		if want := []string{out, filepath.Join(dir, "other.go")}; tt.mode == "skip" {
+			if !slices.Equal(paths, want[1:]) {
+				t.Errorf("mode skip: expected only other.go written, got %v", paths)
+			}
+		} else if !tt.wantErr && !slices.Equal(paths, want) {
+			t.Errorf("mode %q: expected %v written, got %v", tt.mode, want, paths)
+		}
+	}
+
+	// A conflict on any output file fails before the others are written.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "other.go"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteMirroredSource(res, dir, WriteOptions{OutputMode: "error"}); err == nil {
+		t.Errorf("expected mirroring onto other.go to fail")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cut_2.go"), []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteSplitSource(res, filepath.Join(dir, "cut.go"), 2, WriteOptions{OutputMode: "error"}); err == nil {
+		t.Errorf("expected splitting onto cut_2.go to fail")
+	}
+	for _, name := range []string{"entry.go", "cut_1.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written, got %v", name, err)
+		}
+	}
+}
+
+func TestCollectPromotedFields(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "promoted", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Label", "Request", "header", "route"}
+	if names := declNames(decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestAnalyzeGeneratedFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":    "module example.com/x\n\ngo 1.23\n",
+		"entry.go":  "package x\n\nfunc Run() string {\n\treturn name() + helper()\n}\n",
+		"helper.go": "package x\n\nfunc helper() string {\n\treturn \"!\"\n}\n",
+		"gen.go":    "// Code generated by stringer; DO NOT EDIT.\n\npackage x\n\nfunc name() string {\n\treturn \"x\"\n}\n\nfunc unused() {}\n",
+	})
+	entry := filepath.Join(root, "entry.go")
+
+	tests := []struct {
+		analyzer *Analyzer
+		want     []string
+	}{
+		{&Analyzer{}, []string{"Run", "helper", "name"}},
+		{&Analyzer{KeepGenerated: true}, []string{"Run", "helper", "name", "unused"}},
+	}
+	for _, tt := range tests {
+		res, err := tt.analyzer.Analyze(entry)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if names := declNames(res.Decls); !slices.Equal(names, tt.want) {
+			t.Errorf("%+v: expected %v, got %v", *tt.analyzer, tt.want, names)
+		}
+	}
+}
+
+func TestWriteFilteredSourceTypedIota(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "weekdays", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// The enum is kept whole so its members keep their values; Unit is cut.
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	want := "type Weekday int\n\nconst (\n\tSunday Weekday = iota\n\tMonday\n\tTuesday\n\tWednesday\n\tThursday\n\tFriday\n\tSaturday\n)\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("expected the whole enum, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "Unit") {
+		t.Errorf("expected Unit to be cut, got:\n%s", buf.String())
+	}
+
+	// Type-checked on its own, the cut gives the members their values.
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "weekday.go", buf.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("invalid output: %v", err)
+	}
+	pkg, err := new(types.Config).Check("weekdays", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("output does not type-check: %v", err)
+	}
+	c, ok := pkg.Scope().Lookup("Saturday").(*types.Const)
+	if !ok || c.Val().String() != "6" || c.Type().String() != "weekdays.Weekday" {
+		t.Errorf("expected Saturday to be the Weekday 6, got %v", c)
+	}
+}
+
+func TestAnalyzeBatch(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":                   "module example.com/x\n\ngo 1.23\n",
+		"examples/greet/main.go":   "package main\n\nfunc main() {\n\tprintln(greeting())\n}\n",
+		"examples/greet/util.go":   "package main\n\nfunc greeting() string {\n\treturn \"hello\"\n}\n\nfunc farewell() string {\n\treturn \"bye\"\n}\n",
+		"examples/count/main.go":   "package main\n\nfunc main() {\n\tprintln(count)\n}\n",
+		"examples/count/consts.go": "package main\n\nconst count = 3\n\nconst other = 4\n",
+		"examples/count/extra.go":  "package main\n\nfunc extra() int {\n\treturn other\n}\n",
+	})
+	entries := []string{
+		filepath.Join(root, "examples", "greet", "main.go"),
+		filepath.Join(root, "examples", "count", "main.go"),
+		filepath.Join(root, "examples", "count", "extra.go"),
+	}
+	results, err := (&Analyzer{}).AnalyzeBatch(entries)
+	if err != nil {
+		t.Fatalf("AnalyzeBatch failed: %v", err)
+	}
+
+	want := [][]string{{"greeting", "main"}, {"count", "main"}, {"extra", "other"}}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(results))
+	}
+	for i, res := range results {
+		if names := declNames(res.Decls); !slices.Equal(names, want[i]) {
+			t.Errorf("expected %v for %s, got %v", want[i], entries[i], names)
+		}
+		if name := res.Fset.File(res.Entry.Pos()).Name(); name != entries[i] {
+			t.Errorf("expected the entry %s, got %s", entries[i], name)
+		}
+		// The packages were loaded once for all entries.
+		if res.Fset != results[0].Fset {
+			t.Errorf("expected the results to share one load")
+		}
+	}
+}
+
+func TestWriteFilteredSourceSentinelErrors(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "sentinels", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	for _, want := range []string{"import (\n\t\"errors\"\n)\n", `var ErrNotFound = errors.New("not found")`, `const msgNegative = "negative value"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in the cut file, got:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "ErrClosed") {
+		t.Errorf("expected ErrClosed and fmt to be cut, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteFilteredSourceNormalizeWhitespace(t *testing.T) {
+	messy := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/ws\n\ngo 1.23\n",
+		"entry.go": "package ws\r\n\r\n\r\n// Run   runs.   \r\nfunc   Run( )  int {\r\n\treturn   helper( )  +Value   \r\n}\r\n",
+		"other.go": "package ws\r\n\r\nvar (\r\n\tValue   =   1 // one  \r\n\r\n\r\n\tOther = 2\r\n)\r\n\r\n/* helper\r\n   returns one. */\r\nfunc helper()int{   return 1 }   \r\n",
+	})
+	clean := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/ws\n\ngo 1.23\n",
+		"entry.go": "package ws\n\n// Run   runs.\nfunc Run() int {\n\treturn helper() + Value\n}\n",
+		"other.go": "package ws\n\nvar (\n\tValue = 1 // one\n\n\tOther = 2\n)\n\n/*\nhelper\n\n\treturns one.\n*/\nfunc helper() int { return 1 }\n",
+	})
+
+	var outputs [2][]byte
+	for i, root := range []string{messy, clean} {
+		res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := WriteFilteredSourceTo(&buf, res, WriteOptions{NormalizeWhitespace: true}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		outputs[i] = buf.Bytes()
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("expected identical outputs, got:\n%q\nand:\n%q", outputs[0], outputs[1])
+	}
+	if formatted, err := format.Source(outputs[0]); err != nil || !bytes.Equal(formatted, outputs[0]) {
+		t.Errorf("expected the output to be left alone by gofmt, got:\n%s", formatted)
+	}
+
+	// A single gofmt pass leaves this doc comment to the next one.
+	src := []byte("package p\r\n\r\n/* block\r\n   comment */\r\nfunc f() {}\r\n")
+	got, err := WriteOptions{NormalizeWhitespace: true}.format(src)
+	if want := "package p\n\n/*\nblock\n\n\tcomment\n*/\nfunc f() {}\n"; err != nil || string(got) != want {
+		t.Errorf("expected %q, got %q (%v)", want, got, err)
+	}
+}
+
+func TestWriteFilteredSourceTrimBlankLines(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/x\n\ngo 1.23\n",
+		"entry.go": "package x\n\n/*\nUsage:\n\n\n\n\tx run\n*/\n\nfunc Run() string {\n\treturn usage\n}\n\nconst usage = `x\n\n\n\nrun`\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{TrimBlankLines: true}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	// The runs of the raw string are part of its value.
+	literal := "`x\n\n\n\nrun`"
+	if !strings.Contains(buf.String(), literal) {
+		t.Errorf("expected the raw string unchanged, got:\n%s", buf.String())
+	}
+	if rest := strings.Replace(buf.String(), literal, "", 1); strings.Contains(rest, "\n\n\n") {
+		t.Errorf("expected no runs of blank lines, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "/*\nUsage:\n\n\tx run\n*/\n") {
+		t.Errorf("expected the comment's blank lines collapsed, got:\n%s", buf.String())
+	}
+}
+
+func TestFragments(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	frags, err := Fragments(res)
+	if err != nil {
+		t.Fatalf("Fragments failed: %v", err)
+	}
+	want := []string{
+		"func Run() string {\n\thelper()\n\treturn Kept\n}\n",
+		"var (\n\tKept = \"kept\"\n)\n",
+		"// helper prints a greeting.\nfunc helper() {\n\tfmt.Println(\"hello\")\n}\n",
+	}
+	if !slices.Equal(frags, want) {
+		t.Errorf("expected fragments %q, got %q", want, frags)
+	}
+	for _, frag := range frags {
+		if strings.Contains(frag, "package") || strings.Contains(frag, "import") {
+			t.Errorf("expected no package clause or imports, got:\n%s", frag)
+		}
+	}
+}
+
+func TestWriteSourceTo(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	for _, want := range []string{"package patch", "func Run() string", "func helper()", "Kept"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in the cut file, got:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "unused") {
+		t.Errorf("expected unused to be cut, got:\n%s", buf.String())
+	}
+
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if len(files) != 2 || !strings.Contains(files["entry.go"].String(), "func Run() string") ||
+		!strings.Contains(files["other.go"].String(), "func helper()") {
+		t.Errorf("expected entry.go and other.go with their kept declarations, got %v", files)
+	}
+}
+
+func TestBuildOverlay(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "patch"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(filepath.Join(dir, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	overlay, err := BuildOverlay(res)
+	if err != nil {
+		t.Fatalf("BuildOverlay failed: %v", err)
+	}
+
+	cfg := &packages.Config{
+		Mode:    packages.NeedName | packages.NeedSyntax | packages.NeedTypes | packages.NeedImports | packages.NeedDeps,
+		Dir:     dir,
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		t.Fatalf("expected the overlaid package to type-check")
+	}
+	scope := pkgs[0].Types.Scope()
+	if scope.Lookup("Run") == nil || scope.Lookup("unused") != nil {
+		t.Errorf("expected the package to declare Run but not unused, got %v", scope.Names())
+	}
+}
+
+func TestWriteSplitSource(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/split\n\ngo 1.23\n",
+		"entry.go": "package split\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\ntype Greeter struct{ name string }\n\nfunc (g Greeter) Greet() string {\n\treturn fmt.Sprintf(\"hello %s\", shout(g.name))\n}\n\nfunc shout(s string) string {\n\treturn strings.ToUpper(s)\n}\n\nconst Default = \"world\"\n",
+	})
+
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paths, err := WriteSplitSource(res, filepath.Join(root, "out", "entry.go"), 2, WriteOptions{})
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 files, got %v", paths)
+	}
+
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedSyntax | packages.NeedTypes | packages.NeedImports | packages.NeedDeps, Dir: root}, "./out")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		t.Fatalf("expected the split files to compile together")
+	}
+	seen := map[string]bool{}
+	for _, file := range pkgs[0].Syntax {
+		for _, name := range declNames(file.Decls) {
+			if seen[name] {
+				t.Errorf("expected %s to be declared in only one file", name)
+			}
+			seen[name] = true
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("expected the 4 declarations spread over the files, got %v", seen)
+	}
+}
+
+func TestCollectUnexportedFieldTypes(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "fields", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Server", "serverState", "logger", "job", "jobID"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unrelated"] {
+		t.Errorf("expected unrelated type to be dropped")
+	}
+}
+
+func TestAnalyzeLogsPhases(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ranges", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	if _, err := (&Analyzer{}).Analyze(absEntry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	phases := map[string]bool{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event map[string]any
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("invalid JSON log line: %v", err)
+		}
+		if phase, ok := event["phase"].(string); ok {
+			if _, ok := event["duration"].(float64); !ok {
+				t.Errorf("phase %s logged without a duration: %v", phase, event)
+			}
+			phases[phase] = true
+		}
+	}
+	for _, phase := range []string{"load", "traverse"} {
+		if !phases[phase] {
+			t.Errorf("expected a %s phase event, got %v", phase, phases)
+		}
+	}
+}
+
+func TestAutoFixImportsKeepsAliases(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "aliases", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, WriteOptions{}); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := `import (
+	j "encoding/json"
+	str "strings"
+)`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected aliased imports to survive, got:\n%s", content)
+	}
+	if n := strings.Count(string(content), `"encoding/json"`); n != 1 {
+		t.Errorf("expected encoding/json to be imported once, got %d times:\n%s", n, content)
+	}
+}
+
+func TestWriteFilteredSourceDotImports(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "dotimports", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, WriteOptions{}); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := `import (
+	"fmt"
+	. "math"
+	. "strings"
+)`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected dot imports to be kept, got:\n%s", content)
+	}
+	if !strings.Contains(string(content), "return Sqrt(x*x + y*y)") {
+		t.Errorf("expected unqualified reference to survive, got:\n%s", content)
+	}
+
+	// A mirrored file whose declarations are all cut drops its dot import,
+	// which would be unused.
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got := files["circle.go"].String(); got != "package dotimports\n" {
+		t.Errorf("expected circle.go left with its package clause, got:\n%s", got)
+	}
+}
+
+func TestCollectSwitchCaseConsts(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "switches", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"StatusOK", "statusMissing", "okText", "missingText"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["statusTeapot"] {
+		t.Errorf("expected unused const statusTeapot to be dropped")
+	}
+}
+
+func TestCollectIndexedArrayConsts(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "arrays", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	// first and last index the literal, slots is the array length.
+	for _, sym := range []string{"weights", "slots", "first", "last"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unused"] {
+		t.Errorf("expected unused const to be dropped")
+	}
+}
+
+func TestCollectParenthesizedTypes(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "parens", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"newLocal", "local", "inner"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["unused"] {
+		t.Errorf("expected unused type to be dropped")
+	}
+}
+
+func TestCollectGenericInterfaceAssertions(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "genassert", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declNames(decls)
+	want := []string{"Getter", "Impl", "Impl.Get", "Impl.Key", "Impl.Val", "Pair", "Run", "Value", "_", "_", "one"}
+	if !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestCollectSkipsPackageNames(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "aliasimports", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	used, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if used["m"] {
+		t.Errorf("expected the import alias m not to be recorded as used")
+	}
+	if !used["Pi"] {
+		t.Errorf("expected m.Pi to be recorded as used")
+	}
+	if names := declNames(decls); !slices.Equal(names, []string{"Area", "square"}) {
+		t.Errorf("expected Area and square, got %v", names)
+	}
+}
+
+func TestWriteFilteredSourceEmbeddedInterfaces(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "embedded", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// The local interface is cut along, io.Reader stays imported.
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Describe", "Named", "Source"}) {
+		t.Errorf("expected Describe, Named and Source, got %v", names)
+	}
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"io"`) || !strings.Contains(buf.String(), "\tio.Reader\n\tNamed\n") {
+		t.Errorf("expected io imported and both interfaces embedded, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteFilteredSourceStructEmbeddedInterfaces(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "embediface", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// Get is promoted from the embedded Getter, which is kept with Store.
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Getter", "Store", "Use"}) {
+		t.Errorf("expected Getter, Store and Use, got %v", names)
+	}
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"io"`) || !strings.Contains(buf.String(), "\tGetter\n\tio.Reader\n") {
+		t.Errorf("expected io imported and both interfaces embedded, got:\n%s", buf.String())
+	}
+}
+
+func TestAnalyzeTestEntry(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "testentry"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(filepath.Join(dir, "calc_test.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Add", "TestAdd", "add"}) {
+		t.Errorf("expected the test and the code under test, got %v", names)
+	}
+
+	res, err = (&Analyzer{}).Analyze(filepath.Join(dir, "calc_ext_test.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Pkg.Name != "testentry_test" {
+		t.Errorf("expected the external test package, got %s", res.Pkg.ID)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"TestSub"}) {
+		t.Errorf("expected only TestSub, got %v", names)
+	}
+}
+
+func TestAnalyzeTestMain(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "testmain", "double_test.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// TestMain is kept with the setup it runs for every test.
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Double", "TestDouble", "TestMain", "ready", "setup"}) {
+		t.Errorf("expected the test, TestMain and its setup, got %v", names)
+	}
+}
+
+func TestWriteMirroredSourceConsistentImports(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod": "module example.com/x\n\ngo 1.23\n",
+		"a.go":   "package x\n\nimport str \"strings\"\n\nfunc A() string {\n\treturn str.ToUpper(B() + C() + D())\n}\n",
+		"b.go":   "package x\n\nimport \"strings\"\n\nfunc B() string {\n\treturn strings.ToLower(\"B\")\n}\n",
+		"c.go":   "package x\n\nimport \"strings\"\n\nfunc C() string {\n\treturn strings.TrimSpace(\" c \")\n}\n",
+		"d.go":   "package x\n\nimport s \"strings\"\n\nfunc D() string {\n\tstrings := []string{\"d\"}\n\treturn s.Join(strings, \"\")\n}\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "a.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, WriteOptions{ConsistentImports: true}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// Most files import strings unaliased; d.go can't, strings is taken.
+	if got := files["a.go"].String(); !strings.Contains(got, "\t\"strings\"\n") || !strings.Contains(got, "strings.ToUpper(") {
+		t.Errorf("expected a.go to import strings unaliased, got:\n%s", got)
+	}
+	for _, name := range []string{"b.go", "c.go"} {
+		if got := files[name].String(); !strings.Contains(got, "\t\"strings\"\n") {
+			t.Errorf("expected %s unchanged, got:\n%s", name, got)
+		}
+	}
+	if got := files["d.go"].String(); !strings.Contains(got, "\ts \"strings\"\n") || !strings.Contains(got, "s.Join(strings,") {
+		t.Errorf("expected d.go to keep its alias, got:\n%s", got)
+	}
+}
+
+func TestAnalyzeModuleRoot(t *testing.T) {
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal("failed to get working directory:", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal("failed to change directory:", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// The entry is relative to the module root, not to the working directory.
+	res, err := (&Analyzer{ModuleRoot: root}).Analyze(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if res.Pkg.PkgPath != "github.com/chenhg5/gocut/test" {
+		t.Errorf("expected the package resolved in the module, got %s", res.Pkg.PkgPath)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"MainFunc", "MyStruct", "helper"}) {
+		t.Errorf("expected MainFunc, MyStruct and helper, got %v", names)
+	}
+
+	if _, err := (&Analyzer{}).Analyze(filepath.Join("test", "entry.go")); err == nil {
+		t.Errorf("expected the entry not to resolve from the working directory")
+	}
+
+	// So is the file of a keep range; lines 3-5 hold First.
+	ranges := filepath.Join("test", "ranges", "entry.go")
+	res, err = (&Analyzer{ModuleRoot: root, KeepRange: &LineRange{File: ranges, Start: 3, End: 5}}).Analyze(ranges)
+	if err != nil {
+		t.Fatalf("Analyze with a keep range failed: %v", err)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"First", "one"}) {
+		t.Errorf("expected First and one, got %v", names)
+	}
+}
+
+func TestCollectInlineInterfaceAssertions(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "inlineassert", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Asserted", "Badge", "Describe", "Grams", "Grams.String", "Label", "_", "ok", "src"}
+	if names := declNames(decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestCollectReturnedConversions(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "conversions", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Index", "Names", "Wrap", "counter", "lookup", "names"}
+	if names := declNames(decls); !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestEntryPackage(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "testentry"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	entry := filepath.Join(dir, "calc.go")
+	fset := token.NewFileSet()
+	cfg := &packages.Config{Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax, Fset: fset, Dir: dir, Tests: true}
+	pkgs, err := packages.Load(cfg, "file="+entry)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(pkgs) < 2 {
+		t.Fatalf("expected the package and its test variant, got %d packages", len(pkgs))
+	}
+
+	// Whatever the order of the results, the variant holding the entry is
+	// found.
+	for range 2 {
+		slices.Reverse(pkgs)
+		if pkg := entryPackage(fset, pkgs, entry, true); pkg == nil || pkg.ID != pkg.PkgPath+" ["+pkg.PkgPath+".test]" {
+			t.Errorf("expected the test variant of the package, got %v", pkg)
+		}
+		if pkg := entryPackage(fset, pkgs, entry, false); pkg == nil || pkg.ID != pkg.PkgPath {
+			t.Errorf("expected the package itself, got %v", pkg)
+		}
+	}
+	if pkg := entryPackage(fset, pkgs, filepath.Join(dir, "missing.go"), true); pkg != nil {
+		t.Errorf("expected no package for a missing file, got %v", pkg)
+	}
+}
+
+func TestWriteFilteredSourceBlankImports(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/x\n\ngo 1.23\n",
+		"entry.go": "package x\n\nimport (\n\t\"strings\"\n\t_ \"fmt\"\n\t_ \"net/http/pprof\"\n\t\"fmt\"\n\t_ \"net/http/pprof\"\n)\n\nfunc Run() {\n\tfmt.Println(strings.ToUpper(\"x\"))\n}\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "entry.go")
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, WriteOptions{}); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := "import (\n\t\"fmt\"\n\t_ \"net/http/pprof\"\n\t\"strings\"\n)\n"
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected a deduplicated, sorted import block, got:\n%s", content)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	seq, err := (&Analyzer{}).Walk(absEntry)
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	walked := map[string]bool{}
+	for obj, decl := range seq {
+		if declStart(decl) > obj.Pos() || obj.Pos() > decl.End() {
+			t.Errorf("%s is not declared by the declaration it was yielded with", obj.Name())
+		}
+		walked[obj.Name()] = true
+	}
+	if want := declaredNames(res.Decls); !maps.Equal(walked, want) {
+		t.Errorf("expected Walk to yield %v, got %v", want, walked)
+	}
+
+	n := 0
+	for range seq {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("expected the iteration to stop after one declaration, got %d", n)
+	}
+}
+
+func TestCollectMethodsInOtherFiles(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "crossfile", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Counter is declared in counter.go, its methods in methods.go.
+	if names := declNames(decls); !slices.Equal(names, []string{"Count", "Counter", "Counter.Inc", "step"}) {
+		t.Errorf("expected Counter and Counter.Inc, got %v", names)
+	}
+}
+
+func TestWriteFilteredSourceKeepImports(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "entry.go")
+	opts := WriteOptions{KeepImports: []string{"net/http/pprof", "fmt"}}
+	if err := WriteFilteredSource(res, out, opts); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	if err := autoFixImports(out, opts); err != nil {
+		t.Fatalf("autoFixImports failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	// fmt is used by the kept helper, so it needs no blank import.
+	want := "import (\n\t\"fmt\"\n\t_ \"net/http/pprof\"\n)\n"
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected the forced import to remain, got:\n%s", content)
+	}
+}
+
+func TestCollectTypeArguments(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "typeargs", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Make", "MakeMap", "Row", "Key", "Val", "rows", "index"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["Unused"] {
+		t.Errorf("expected Unused to be dropped")
+	}
+}
+
+func TestWriteFilteredSourceGenericAliases(t *testing.T) {
+	// Generic aliases need go 1.24.
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/x\n\ngo 1.24\n",
+		"entry.go": "package x\n\nfunc Sum(v Vec[Num]) (s Num) {\n\tfor _, n := range v {\n\t\ts += n\n\t}\n\treturn s\n}\n",
+		"vec.go":   "package x\n\ntype Vec[T Number] = []T\n\ntype Number interface {\n\t~int | ~float64\n}\n\ntype Num int\n\ntype Pair[K comparable, V any] = map[K]V\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Num", "Number", "Sum", "Vec"}) {
+		t.Errorf("expected the alias and the types it refers to, got %v", names)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "type Vec[T Number] = []T\n") {
+		t.Errorf("expected the alias with its type parameters, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteFilteredSourceMultiNameSpecs(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "multivars", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// Only b and y are used, so f is not needed for a and x.
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Get", "b", "g", "h", "y"}) {
+		t.Errorf("expected b, y and their initializers, got %v", names)
+	}
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	for _, want := range []string{"var b = g()\n", "\ty = h() // line comment\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in the output, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteFilteredSourceConstraintImports(t *testing.T) {
+	// A local stand-in for golang.org/x/exp/constraints.
+	root := writeTree(t, map[string]string{
+		"app/go.mod":               "module example.com/x\n\ngo 1.23\n\nrequire golang.org/x/exp v0.0.0\n\nreplace golang.org/x/exp => ../exp\n",
+		"app/entry.go":             "package x\n\nimport (\n\t\"cmp\"\n\n\t\"golang.org/x/exp/constraints\"\n)\n\nfunc Max[T constraints.Ordered](a, b T) T {\n\treturn max(a, b)\n}\n\nfunc Min[T cmp.Ordered](a, b T) T {\n\treturn min(a, b)\n}\n\nfunc First[T any](s []T) T {\n\treturn s[0]\n}\n",
+		"exp/go.mod":               "module golang.org/x/exp\n\ngo 1.23\n",
+		"exp/constraints/order.go": "package constraints\n\ntype Ordered interface {\n\t~int | ~float64 | ~string\n}\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "app", "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteFilteredSourceTo(&buf, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	// any is predeclared and needs no import.
+	want := "import (\n\t\"cmp\"\n\t\"golang.org/x/exp/constraints\"\n)\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("expected the constraint packages imported, got:\n%s", buf.String())
+	}
+}
+
+func TestAnalyzeSourceOverlay(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "ranges"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	src := []byte("package ranges\n\nfunc Second() int {\n\treturn two()\n}\n")
+	res, err := (&Analyzer{}).AnalyzeSource("entry.go", src, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(res.Decls)
+	for _, sym := range []string{"Second", "two"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	for _, sym := range []string{"First", "one"} {
+		if names[sym] {
+			t.Errorf("expected %s, only referenced on disk, to be dropped", sym)
+		}
+	}
+}
+
+func TestAnalyzePattern(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":       "module example.com/multi\n\ngo 1.23\n",
+		"app/main.go":  "package main\n\nimport \"example.com/multi/lib\"\n\nfunc main() {\n\tprintln(lib.Greet())\n}\n\nfunc unused() {}\n",
+		"lib/lib.go":   "package lib\n\nfunc Greet() string {\n\treturn prefix + \"world\"\n}\n\nconst prefix = \"hello \"\n\nfunc Other() string {\n\treturn \"other\"\n}\n",
+		"idle/idle.go": "package idle\n\nfunc Idle() {}\n",
+	})
+	out := t.TempDir()
+
+	results, err := (&Analyzer{}).AnalyzePattern(root, []string{"./..."}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kept := map[string]map[string]bool{}
+	for _, res := range results {
+		kept[res.Pkg.PkgPath] = declaredNames(res.Decls)
+		if _, err := WriteMirroredSource(res, patternOutputDir(out, res.Pkg), WriteOptions{}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if len(kept) != 2 {
+		t.Errorf("expected results for the app and lib packages only, got %v", kept)
+	}
+	for _, sym := range []string{"Greet", "prefix"} {
+		if !kept["example.com/multi/lib"][sym] {
+			t.Errorf("expected lib decl for %s not found", sym)
+		}
+	}
+	if kept["example.com/multi/lib"]["Other"] || kept["example.com/multi/app"]["unused"] {
+		t.Errorf("expected unreachable decls to be dropped, got %v", kept)
+	}
+	if _, err := os.Stat(filepath.Join(out, "lib", "lib.go")); err != nil {
+		t.Errorf("expected the lib cut to mirror its package directory: %v", err)
+	}
+
+	results, err = (&Analyzer{}).AnalyzePattern(root, []string{"./..."}, []string{"example.com/multi/lib.Other"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || !declaredNames(results[0].Decls)["Other"] || declaredNames(results[0].Decls)["Greet"] {
+		t.Errorf("expected only the designated root Other to be kept")
+	}
+}
+
+func TestAnalyzePatternFieldTypes(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":         "module example.com/fields\n\ngo 1.23\n",
+		"main.go":        "package main\n\nimport \"example.com/fields/other\"\n\ntype Holder struct {\n\tV other.T\n}\n\nfunc main() {\n\tvar h Holder\n\tprintln(&h)\n}\n",
+		"other/other.go": "package other\n\ntype T struct {\n\tN int\n}\n\ntype Unused struct{}\n",
+	})
+
+	results, err := (&Analyzer{}).AnalyzePattern(root, []string{"./..."}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kept := map[string][]string{}
+	for _, res := range results {
+		kept[res.Pkg.PkgPath] = declNames(res.Decls)
+	}
+	// The type of the field is only named in the struct, through its
+	// package qualifier.
+	want := map[string][]string{
+		"example.com/fields":       {"Holder", "main"},
+		"example.com/fields/other": {"T"},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected %v, got %v", want, kept)
+	}
+}
+
+func TestAnalyzePatternSameNames(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod": "module example.com/names\n\ngo 1.23\n",
+		"a/a.go": "package a\n\nimport \"example.com/names/b\"\n\nfunc Run() string {\n\treturn helper() + b.Value{}.Name()\n}\n\nfunc helper() string {\n\treturn \"a\"\n}\n\ntype Local struct{}\n\nfunc (Local) Name() string {\n\treturn \"local\"\n}\n",
+		"b/b.go": "package b\n\ntype Value struct{}\n\nfunc (Value) Name() string {\n\treturn helper()\n}\n\nfunc helper() string {\n\treturn \"b\"\n}\n\ntype Other struct{}\n\nfunc (Other) helper() {}\n",
+	})
+
+	results, err := (&Analyzer{}).AnalyzePattern(root, []string{"./..."}, []string{"example.com/names/a.Run"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	kept := map[string]string{}
+	for _, res := range results {
+		kept[res.Pkg.PkgPath] = strings.Join(declNames(res.Decls), ",")
+	}
+	if got, want := kept["example.com/names/a"], "Run,helper"; got != want {
+		t.Errorf("package a kept %s, want %s", got, want)
+	}
+	if got, want := kept["example.com/names/b"], "Value,Value.Name,helper"; got != want {
+		t.Errorf("package b kept %s, want %s", got, want)
+	}
+}
+
+func TestCopyEmbeds(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":           "module example.com/embeds\n\ngo 1.23\n",
+		"entry.go":         "package embeds\n\nfunc Version() string {\n\treturn version\n}\n",
+		"assets.go":        "package embeds\n\nimport _ \"embed\"\n\n//go:embed data/version.txt\nvar version string\n\n//go:embed data/unused.txt\nvar unused string\n",
+		"data/version.txt": "1.0.0\n",
+		"data/unused.txt":  "unused\n",
+	})
+	out := t.TempDir()
+	outFile := filepath.Join(out, "entry.go")
+
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := WriteFilteredSource(res, outFile, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if err := CopyEmbeds(res, out); err != nil {
+		t.Fatalf("copying embeds failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	for _, want := range []string{"_ \"embed\"", "//go:embed data/version.txt\nvar version string"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, content)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "data", "version.txt")); err != nil || string(data) != "1.0.0\n" {
+		t.Errorf("expected the embedded file to be copied, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "data", "unused.txt")); err == nil {
+		t.Errorf("expected the file embedded by a dropped variable not to be copied")
+	}
+}
+
+func TestWriteFilteredSourceSharedGroupComments(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "sharedcomments", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := `// Defaults shared by every client.
+var (
+	retries = 3 // retries per request
+)
+
+var (
+	// Limits of the pool, in connections.
+
+	limit = 8
+)
+
+var (
+	// Ratios of the cache.
+
+	ratio = 2
+	// more ratios to come
+)
+`
+	if !strings.HasSuffix(string(content), want) {
+		t.Errorf("expected shared comments to stay with their kept specs, got:\n%s", content)
+	}
+}
+
+func TestWriteFilteredSourcePackageName(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":  "module example.com/cmd\n\ngo 1.23\n",
+		"main.go": "package main\n\nfunc main() {\n\tprintln(greeting)\n}\n\nconst greeting = \"hi\"\n",
+	})
+	out := filepath.Join(t.TempDir(), "main.go")
+
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "main.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	if err := WriteFilteredSource(res, out, WriteOptions{PackageName: "repro"}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "package repro\n") {
+		t.Errorf("expected the package clause to be renamed, got:\n%s", content)
+	}
+	if !strings.Contains(buf.String(), "func main") {
+		t.Errorf("expected a warning about keeping func main, got %q", buf.String())
+	}
+}
+
+func TestCollectChannelDirections(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "channels", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Job", "Result", "Reason"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["Idle"] {
+		t.Errorf("expected unused type Idle to be dropped")
+	}
+}
+
+func TestMainQuiet(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ranges", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", t.TempDir(), "-quiet", "-no-goimports"}
+
+	if code := run(); code != 0 {
+		t.Fatalf("expected exit status 0, got %d\n%s", code, buf.String())
+	}
+
+	if strings.Contains(buf.String(), "symbol=") || strings.Contains(buf.String(), "Summary") {
+		t.Errorf("expected no symbol dump or summary with -quiet, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Cut successfully") {
+		t.Errorf("expected the success line with -quiet, got:\n%s", buf.String())
+	}
+}
+
+func TestMainSplitLogsWrittenFiles(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ranges", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := t.TempDir()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", out, "-split", "2", "-quiet", "-no-goimports"}
+
+	if code := run(); code != 0 {
+		t.Fatalf("expected exit status 0, got %d\n%s", code, buf.String())
+	}
+
+	// The split parts are logged; entry.go itself is never written.
+	if _, err := os.Stat(filepath.Join(out, "entry.go")); !os.IsNotExist(err) {
+		t.Errorf("expected no entry.go with -split, got err %v", err)
+	}
+	if !strings.Contains(buf.String(), filepath.Join(out, "entry_1.go")) || strings.Contains(buf.String(), "output="+filepath.Join(out, "entry.go")) {
+		t.Errorf("expected the split files in the success line, got:\n%s", buf.String())
+	}
+}
+
+func TestMainStrict(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "empty", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", t.TempDir(), "-strict", "-no-goimports"}
+
+	if code := run(); code != 1 {
+		t.Errorf("expected exit status 1 with nothing reachable, got %d", code)
+	}
+	if !strings.Contains(buf.String(), "nothing is reachable") {
+		t.Errorf("expected the strict failure to be logged, got:\n%s", buf.String())
+	}
+}
+
+func TestMainFailOnDeprecated(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "deprecated", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	out := t.TempDir()
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", out, "-fail-on-deprecated", "-no-goimports"}
+
+	if code := run(); code != 1 {
+		t.Errorf("expected exit status 1 with deprecated declarations reachable, got %d", code)
+	}
+	if !strings.Contains(buf.String(), "deprecated declarations are reachable") {
+		t.Errorf("expected the deprecated declarations to be logged, got:\n%s", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(out, "entry.go")); !os.IsNotExist(err) {
+		t.Errorf("expected no output written, got %v", err)
+	}
+}
+
+func TestMainFlagConflicts(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+
+	// Flags that only apply to other modes are rejected rather than ignored.
+	tests := [][]string{
+		{"-input", absEntry, "-no-inline", "example.com/internal"},
+		{"-input", absEntry, "-exclude-package", "example.com/gen"},
+		{"-input", absEntry, "-fail-on-missing", "-split", "2"},
+		{"-input", absEntry, "-split", "2", "-since", "HEAD"},
+		{"-input", absEntry, "-count-only", "-since", "HEAD"},
+		{"-input", absEntry, "-fail-on-missing", "-output-format", "patch"},
+		{"-input", absEntry, "-fail-on-missing", "-count-only"},
+		{"-input-glob", absEntry, "-fail-on-missing"},
+	}
+	for _, args := range tests {
+		buf.Reset()
+		out := t.TempDir()
+		flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+		os.Args = append([]string{"gocut", "-output", out, "-no-goimports"}, args...)
+		if code := run(); code != 1 {
+			t.Errorf("%v: expected exit status 1, got %d\n%s", args, code, buf.String())
+		}
+		if entries, _ := os.ReadDir(out); len(entries) != 0 {
+			t.Errorf("%v: expected nothing written, got %d files", args, len(entries))
+		}
+	}
+}
+
+func TestMainAfter(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp not available")
+	}
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	copies := t.TempDir()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+	os.Args = []string{"gocut", "-input", absEntry, "-output", t.TempDir(), "-mirror", "-quiet", "-no-goimports", "-after", "cp {} " + copies}
+
+	if code := run(); code != 0 {
+		t.Fatalf("expected exit status 0, got %d\n%s", code, buf.String())
+	}
+
+	// The command copied each of the two mirrored files once.
+	entries, err := os.ReadDir(copies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"entry.go", "other.go"}) {
+		t.Errorf("expected the command to run on entry.go and other.go, got %v\n%s", names, buf.String())
+	}
+
+	if err := runAfter("cp {}", "missing.go"); err == nil {
+		t.Errorf("expected a failing command to be reported")
+	}
+}
+
+func TestMainProfiles(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	for _, failing := range []bool{false, true} {
+		for _, path := range []string{cpu, mem} {
+			os.Remove(path)
+		}
+		flag.CommandLine = flag.NewFlagSet("gocut", flag.ExitOnError)
+		os.Args = []string{"gocut", "-input", absEntry, "-output", t.TempDir(), "-quiet", "-no-goimports", "-cpuprofile", cpu, "-memprofile", mem}
+		want := 0
+		if failing {
+			// The profiles are written even when the cut fails.
+			os.Args = append(os.Args, "-keep-range", "entry.go")
+			want = 1
+		}
+
+		if code := run(); code != want {
+			t.Fatalf("expected exit status %d, got %d\n%s", want, code, buf.String())
+		}
+
+		for _, path := range []string{cpu, mem} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Errorf("expected a profile written to %s: %v\n%s", path, err, buf.String())
+			} else if fi.Size() == 0 {
+				t.Errorf("expected %s to be non-empty", path)
+			}
+		}
+	}
+}
+
+func TestCollectConstraintMethodSets(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "methodsets", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	names := declaredNames(decls)
+	for _, sym := range []string{"Ordered", "CompareOptions", "compareMode", "Min", "Version", "Less"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["Unrelated"] {
+		t.Errorf("expected unused type Unrelated to be dropped")
+	}
+}
+
+func TestWriteFilteredSourceFailureLeavesNoFile(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ranges", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// A bad declaration prints as text that does not format.
+	res.Decls = append(res.Decls, &ast.BadDecl{From: res.Decls[0].Pos(), To: res.Decls[0].Pos()})
+
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err == nil {
+		t.Fatal("expected formatting to fail")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading output directory failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no output or temporary file after the failure, got %v", entries)
+	}
+}
+
+func TestCollectFuncValuedFields(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "funcfields", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	for _, want := range []string{"func cleanup()", "func release()", "func logError(err error)"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "func announce()") {
+		t.Errorf("expected unused function announce to be dropped, got:\n%s", content)
+	}
+}
+
+func TestWriteFilteredSourceStubBodies(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "stubs", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.go")
+
+	res, err := (&Analyzer{StubBodies: true}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if err := WriteFilteredSource(res, out, WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	want := `// Open connects to addr.
+func Open(addr Address, opts *Options) (*Conn, error) {
+	panic("not implemented")
+}
+`
+	if !strings.Contains(string(content), want) {
+		t.Errorf("expected the body of Open to be stubbed, got:\n%s", content)
+	}
+	names := declaredNames(res.Decls)
+	for _, sym := range []string{"Address", "Options", "Conn"} {
+		if !names[sym] {
+			t.Errorf("expected signature type %s to be kept", sym)
+		}
+	}
+	for _, sym := range []string{"dial", "handshake"} {
+		if names[sym] {
+			t.Errorf("expected %s, only used by the stubbed body, to be dropped", sym)
+		}
+	}
+}
+
+func TestCollectTypeSwitchImplicits(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "implicits", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	_, decls, err := CollectUsedDeclarations(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each case variable has the type named by its case, or that of the
+	// switched expression, so the types it needs are visited already.
+	names := declaredNames(decls)
+	for _, sym := range []string{"Shape", "Circle", "Rect", "Polygon", "Point", "area"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["Triangle"] {
+		t.Errorf("expected unused type Triangle to be dropped")
+	}
+}
+
+func TestAnalyzePatternConstExpressions(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":     "module example.com/x\n\ngo 1.23\n",
+		"lib/lib.go": "package lib\n\nconst (\n\tShift = 2\n\tOther = 9\n)\n",
+		"app/app.go": "package app\n\nimport (\n\t\"math\"\n\n\t\"example.com/x/lib\"\n)\n\nconst (\n\tBits   = 4\n\tMask   = (1<<Bits - 1) << (lib.Shift * (Width / 8))\n\tWidth  = 16\n\tLimit  = math.MaxInt16 &^ Mask\n\tunused = 1\n)\n\nfunc Use() int {\n\treturn Limit\n}\n",
+	})
+
+	results, err := (&Analyzer{}).AnalyzePattern(root, []string{"./..."}, []string{"example.com/x/app.Use"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kept := map[string][]string{}
+	for _, res := range results {
+		kept[res.Pkg.PkgPath] = declNames(res.Decls)
+	}
+	want := map[string][]string{
+		"example.com/x/app": {"Bits", "Limit", "Mask", "Use", "Width"},
+		"example.com/x/lib": {"Shift"},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected %v, got %v", want, kept)
+	}
+}
+
+func TestAnalyzePatternNoInline(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":                "module example.com/cmd\n\ngo 1.23\n",
+		"main.go":               "package main\n\nimport \"example.com/cmd/internal/store\"\n\nfunc main() {\n\tprintln(store.Get())\n}\n",
+		"internal/store/get.go": "package store\n\nfunc Get() string {\n\treturn \"value\"\n}\n",
+	})
+
+	results, err := (&Analyzer{NoInline: []string{"example.com/cmd/internal"}}).AnalyzePattern(root, []string{"./..."}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Pkg.PkgPath != "example.com/cmd" {
+		t.Fatalf("expected only the main package to be cut, got %d results", len(results))
+	}
+
+	out := t.TempDir()
+	if _, err := WriteMirroredSource(results[0], out, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(out, "main.go"))
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	if !strings.Contains(string(content), `"example.com/cmd/internal/store"`) {
+		t.Errorf("expected the internal package to stay imported, got:\n%s", content)
+	}
+}
+
+func TestAnalyzePatternExclude(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":          "module example.com/cmd\n\ngo 1.23\n",
+		"main.go":         "package main\n\nimport (\n\t\"example.com/cmd/gen\"\n\t\"example.com/cmd/gen/util\"\n)\n\nfunc main() {\n\tprintln(gen.Table(), util.Pad())\n}\n",
+		"gen/table.go":    "package gen\n\nfunc Table() string {\n\treturn \"table\"\n}\n",
+		"gen/util/pad.go": "package util\n\nfunc Pad() string {\n\treturn \" \"\n}\n",
+	})
+
+	results, err := (&Analyzer{Exclude: []string{"example.com/cmd/gen"}}).AnalyzePattern(root, []string{"./..."}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var cut []string
+	var cmd *Result
+	for _, res := range results {
+		cut = append(cut, res.Pkg.PkgPath)
+		if res.Pkg.Name == "main" {
+			cmd = res
+		}
+	}
+	slices.Sort(cut)
+	// Unlike -no-inline, the packages below an excluded one are still cut.
+	if want := []string{"example.com/cmd", "example.com/cmd/gen/util"}; !slices.Equal(cut, want) {
+		t.Fatalf("expected %v to be cut, got %v", want, cut)
+	}
+
+	out := t.TempDir()
+	if _, err := WriteMirroredSource(cmd, out, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(out, "main.go"))
+	if err != nil {
+		t.Fatalf("reading output failed: %v", err)
+	}
+	if !strings.Contains(string(content), `"example.com/cmd/gen"`) {
+		t.Errorf("expected the excluded package to stay imported, got:\n%s", content)
+	}
+}
+
+func TestAnalyzePatternSkipGenerated(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":        "module example.com/cmd\n\ngo 1.23\n",
+		"main.go":       "package main\n\nimport (\n\t\"example.com/cmd/lib\"\n\t\"example.com/cmd/pb\"\n)\n\nfunc main() {\n\tprintln(pb.Name(), lib.Greet())\n}\n",
+		"pb/name.go":    "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n\nfunc Name() string {\n\treturn \"pb\"\n}\n",
+		"lib/greet.go":  "package lib\n\nfunc Greet() string {\n\treturn prefix() + \"world\"\n}\n\nfunc Other() {}\n",
+		"lib/prefix.go": "// Code generated by hand. DO NOT EDIT.\n\npackage lib\n\nfunc prefix() string {\n\treturn \"hello \"\n}\n\nfunc unused() {}\n",
+	})
+
+	results, err := (&Analyzer{SkipGenerated: true}).AnalyzePattern(root, []string{"./..."}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kept := map[string][]string{}
+	for _, res := range results {
+		kept[res.Pkg.PkgPath] = declNames(res.Decls)
+	}
+	// The generated package stays imported, while the generated file of the
+	// cut lib package comes with it so that it compiles.
+	want := map[string][]string{
+		"example.com/cmd":     {"main"},
+		"example.com/cmd/lib": {"Greet", "prefix"},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("expected %v, got %v", want, kept)
+	}
+}
+
+func TestCollectInterfaceFieldMethods(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ifacefields", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	for _, keepAll := range []bool{false, true} {
+		res, err := (&Analyzer{KeepAllMethods: keepAll}).Analyze(absEntry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		names := strings.Join(declNames(res.Decls), ",")
+		want := "Lookup,Service,Store,memStore,memStore.Get"
+		if keepAll {
+			want = "Lookup,Service,Store,memStore,memStore.Get,memStore.Reset"
+		}
+		if names != want {
+			t.Errorf("KeepAllMethods=%v: kept %s, want %s", keepAll, names, want)
+		}
+	}
+}
+
+func TestWriteSourceLineDirectives(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":   "module example.com/ld\n\ngo 1.23\n",
+		"entry.go": "package ld\n\nfunc Run() int {\n\treturn gen()\n}\n",
+		"gen.go":   "package ld\n\n//line template.tmpl:10\nfunc gen() int {\n\treturn 1\n}\n\n//line template.tmpl:40\nfunc unused() int {\n\treturn 0\n}\n",
+	})
+	res, err := (&Analyzer{}).Analyze(filepath.Join(root, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	out := t.TempDir()
+	if err := WriteFilteredSource(res, filepath.Join(out, "entry.go"), WriteOptions{}); err != nil {
+		t.Fatalf("WriteFilteredSource failed: %v", err)
+	}
+	mirrored := filepath.Join(out, "mirror")
+	if _, err := WriteMirroredSource(res, mirrored, WriteOptions{}); err != nil {
+		t.Fatalf("WriteMirroredSource failed: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(out, "entry.go"), filepath.Join(mirrored, "gen.go")} {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading output failed: %v", err)
+		}
+		if !strings.Contains(string(content), "//line template.tmpl:10\nfunc gen() int {") {
+			t.Errorf("expected the //line directive to stay above gen in %s, got:\n%s", filepath.Base(path), content)
+		}
+		if strings.Contains(string(content), "template.tmpl:40") {
+			t.Errorf("expected the directive of the dropped function to go with it, got:\n%s", content)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// Edit replaces the bytes in [Start, End) of a file with New.
+type Edit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	New   string `json:"new"`
+}
+
+// FileEdits lists the edits to apply to one file.
+type FileEdits struct {
+	File  string `json:"file"`
+	Edits []Edit `json:"edits"`
+}
+
+// ComputeEdits returns the edits removing, from every file of the analyzed
+// package, the declarations and imports the cut drops. Applying them yields
+// the same declarations as the cut output without rewriting whole files.
+// Files that need no edits are omitted.
+func ComputeEdits(res *Result) ([]FileEdits, error) {
+	info := res.Pkg.TypesInfo
+
+	keptDecls := map[ast.Decl]bool{}
+	// keptSpecs maps the keys of each kept spec to its kept version, a copy
+	// if it was trimmed to some of its names.
+	keptSpecs := map[token.Pos]ast.Spec{}
+	usedPkgs := map[*types.PkgName]bool{}
+	// keptFuncs maps the position of each kept function to its kept
+	// declaration, a copy if its body was replaced by a stub.
+	keptFuncs := map[token.Pos]*ast.FuncDecl{}
+	for _, decl := range res.Decls {
+		keptDecls[decl] = true
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			keptFuncs[fn.Pos()] = fn
+		}
+		if gd, ok := decl.(*ast.GenDecl); ok {
+			for _, spec := range gd.Specs {
+				for _, pos := range specKeys(spec) {
+					keptSpecs[pos] = spec
+				}
+			}
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if pkgName, ok := info.Uses[id].(*types.PkgName); ok {
+					usedPkgs[pkgName] = true
+				}
+			}
+			return true
+		})
+	}
+
+	var result []FileEdits
+	for _, file := range res.Pkg.Syntax {
+		filename := res.Fset.File(file.Pos()).Name()
+		src, err := res.source(filename)
+		if err != nil {
+			return nil, err
+		}
+		tf := res.Fset.File(file.Pos())
+		remove := func(start, end token.Pos) Edit {
+			s, e := lineSpan(src, tf.Offset(start), tf.Offset(end))
+			return Edit{Start: s, End: e}
+		}
+
+		var edits []Edit
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if kept := keptFuncs[d.Pos()]; kept == nil {
+					edits = append(edits, remove(declStart(d), d.End()))
+				} else if kept != d && d.Body != nil {
+					edits = append(edits, Edit{
+						Start: tf.Offset(d.Body.Lbrace),
+						End:   tf.Offset(d.Body.Rbrace) + 1,
+						New:   "{\n\tpanic(\"not implemented\")\n}",
+					})
+				}
+			case *ast.GenDecl:
+				var dropped []ast.Spec
+				for _, spec := range d.Specs {
+					if d.Tok == token.IMPORT {
+						if !importUsed(spec.(*ast.ImportSpec), info, usedPkgs) {
+							dropped = append(dropped, spec)
+						}
+						continue
+					}
+					var kept ast.Spec
+					for _, pos := range specKeys(spec) {
+						if keptSpecs[pos] != nil {
+							kept = keptSpecs[pos]
+						}
+					}
+					if kept == nil && !keptDecls[d] {
+						dropped = append(dropped, spec)
+					} else if kept != nil && kept != spec {
+						// Only the names and values are replaced, the
+						// comments around them stay in place.
+						trimmed := *kept.(*ast.ValueSpec)
+						trimmed.Doc, trimmed.Comment = nil, nil
+						var buf bytes.Buffer
+						if err := printer.Fprint(&buf, res.Fset, &trimmed); err != nil {
+							return nil, err
+						}
+						edits = append(edits, Edit{Start: tf.Offset(spec.Pos()), End: tf.Offset(spec.End()), New: buf.String()})
+					}
+				}
+				if len(dropped) == len(d.Specs) {
+					edits = append(edits, remove(declStart(d), d.End()))
+					continue
+				}
+				for _, spec := range dropped {
+					start, end := specRange(spec)
+					edits = append(edits, remove(start, end))
+				}
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		sort.Slice(edits, func(i, j int) bool { return edits[i].Start < edits[j].Start })
+		result = append(result, FileEdits{File: filename, Edits: edits})
+	}
+	return result, nil
+}
+
+// importUsed reports whether the package imported by spec is referenced by
+// the kept declarations. Blank and dot imports are always kept.
+func importUsed(spec *ast.ImportSpec, info *types.Info, usedPkgs map[*types.PkgName]bool) bool {
+	var obj types.Object
+	if spec.Name != nil {
+		if spec.Name.Name == "_" || spec.Name.Name == "." {
+			return true
+		}
+		obj = info.Defs[spec.Name]
+	} else {
+		obj = info.Implicits[spec]
+	}
+	pkgName, ok := obj.(*types.PkgName)
+	return !ok || usedPkgs[pkgName]
+}
+
+// declStart returns the start of decl including its doc comment.
+func declStart(decl ast.Decl) token.Pos {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+	return decl.Pos()
+}
+
+// specRange returns the extent of spec including its doc and line comments.
+func specRange(spec ast.Spec) (token.Pos, token.Pos) {
+	start, end := spec.Pos(), spec.End()
+	var doc, comment *ast.CommentGroup
+	switch s := spec.(type) {
+	case *ast.ImportSpec:
+		doc, comment = s.Doc, s.Comment
+	case *ast.ValueSpec:
+		doc, comment = s.Doc, s.Comment
+	case *ast.TypeSpec:
+		doc, comment = s.Doc, s.Comment
+	}
+	if doc != nil {
+		start = doc.Pos()
+	}
+	if comment != nil {
+		end = comment.End()
+	}
+	return start, end
+}
+
+// lineSpan widens [start, end) to whole lines when the range is alone on its
+// lines, and swallows one following blank line if the range is preceded by
+// one, so removals don't leave gaps behind.
+func lineSpan(src []byte, start, end int) (int, int) {
+	s := start
+	for s > 0 && (src[s-1] == ' ' || src[s-1] == '\t') {
+		s--
+	}
+	if s > 0 && src[s-1] != '\n' {
+		return start, end
+	}
+	e := end
+	for e < len(src) && (src[e] == ' ' || src[e] == '\t') {
+		e++
+	}
+	if e < len(src) && src[e] != '\n' {
+		return start, end
+	}
+	if e < len(src) {
+		e++
+	}
+	if s >= 2 && src[s-2] == '\n' && e < len(src) && src[e] == '\n' {
+		e++
+	}
+	return s, e
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// applyEdits applies edits to src, which must not overlap.
+func applyEdits(src []byte, edits []Edit) []byte {
+	sorted := append([]Edit(nil), edits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start > sorted[j].Start })
+	out := append([]byte(nil), src...)
+	for _, e := range sorted {
+		out = append(out[:e.Start], append([]byte(e.New), out[e.End:]...)...)
+	}
+	return out
+}
+
+func TestComputeEdits(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	edits, err := ComputeEdits(res)
+	if err != nil {
+		t.Fatalf("ComputeEdits failed: %v", err)
+	}
+	if len(edits) != 1 || filepath.Base(edits[0].File) != "other.go" {
+		t.Fatalf("expected edits for other.go only, got %+v", edits)
+	}
+
+	src, err := os.ReadFile(edits[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := applyEdits(src, edits[0].Edits)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "other.go", patched, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("patched file does not parse: %v\n%s", err, patched)
+	}
+
+	// The patched package must declare exactly what the cut output keeps.
+	entry, err := parser.ParseFile(fset, absEntry, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := declaredNames(append(entry.Decls, file.Decls...))
+	want := declaredNames(res.Decls)
+	for name := range want {
+		if !got[name] {
+			t.Errorf("patched package lost %s", name)
+		}
+	}
+	for name := range got {
+		if !want[name] {
+			t.Errorf("patched package still declares %s", name)
+		}
+	}
+
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "strings" {
+			t.Errorf("expected unused import strings to be removed\n%s", patched)
+		}
+	}
+}
+
+func TestComputeEditsTrimsNames(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "multivars", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	edits, err := ComputeEdits(res)
+	if err != nil {
+		t.Fatalf("ComputeEdits failed: %v", err)
+	}
+	if len(edits) != 1 {
+		t.Fatalf("expected edits for vars.go only, got %+v", edits)
+	}
+	src, err := os.ReadFile(edits[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := applyEdits(src, edits[0].Edits)
+
+	file, err := parser.ParseFile(token.NewFileSet(), "vars.go", patched, 0)
+	if err != nil {
+		t.Fatalf("patched file does not parse: %v\n%s", err, patched)
+	}
+	if got := declNames(file.Decls); !slices.Equal(got, []string{"b", "g", "h", "y"}) {
+		t.Errorf("expected b, y and their initializers to remain, got %v\n%s", got, patched)
+	}
+}
+
+func TestComputeEditsOverlay(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "patch"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// The unsaved buffer is longer than entry.go on disk, so offsets into
+	// the file on disk would cut it in the wrong places.
+	src := []byte("package patch\n\n// Run returns Kept.\nfunc Run() string {\n\treturn Kept\n}\n\n// Draft is not in the kept range.\nfunc Draft() {\n\thelper()\n}\n")
+	analyzer := &Analyzer{KeepRange: &LineRange{File: filepath.Join(dir, "entry.go"), Start: 3, End: 6}}
+	res, err := analyzer.AnalyzeSource("entry.go", src, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	edits, err := ComputeEdits(res)
+	if err != nil {
+		t.Fatalf("ComputeEdits failed: %v", err)
+	}
+	var patched []byte
+	for _, fe := range edits {
+		if filepath.Base(fe.File) == "entry.go" {
+			patched = applyEdits(src, fe.Edits)
+		}
+	}
+	want := "package patch\n\n// Run returns Kept.\nfunc Run() string {\n\treturn Kept\n}\n\n"
+	if string(patched) != want {
+		t.Errorf("expected the buffer patched to %q, got %q", want, patched)
+	}
+}
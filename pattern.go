@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// AnalyzePattern loads the packages matching patterns, relative to dir, and
+// collects the declarations reachable from roots across all of them. Roots
+// are qualified as "import/path.Name"; without any, the main functions of
+// the matched main packages are used. Packages under a.NoInline or in
+// a.Exclude, and with a.SkipGenerated those made only of generated files,
+// are not cut and stay imported as they are. It returns a Result
+// for each cut package keeping at least one declaration. Their Entry is nil.
+func (a *Analyzer) AnalyzePattern(dir string, patterns []string, roots []string) ([]*Result, error) {
+	fset := token.NewFileSet()
+	env, err := a.env()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps | packages.NeedModule,
+		Fset: fset,
+		Dir:  dir,
+		Env:  env,
+	}
+	if a.Tags != "" {
+		cfg.BuildFlags = []string{"-tags=" + a.Tags}
+	}
+
+	start := time.Now()
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil || len(pkgs) == 0 {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	slog.Debug("Phase done", "phase", "load", "packages", len(pkgs), "duration", time.Since(start))
+	start = time.Now()
+	var extracted []*packages.Package
+	for _, pkg := range pkgs {
+		if !a.leftImported(pkg) {
+			extracted = append(extracted, pkg)
+		}
+	}
+	pkgs = extracted
+
+	var nodes []ast.Node
+	if len(roots) == 0 {
+		for _, pkg := range pkgs {
+			if pkg.Name == "main" {
+				nodes = append(nodes, patternRoots(pkg, "main")...)
+			}
+		}
+	}
+	for _, root := range roots {
+		i := strings.LastIndex(root, ".")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid root %q, want import/path.Name", root)
+		}
+		var found []ast.Node
+		for _, pkg := range pkgs {
+			if pkg.PkgPath == root[:i] {
+				found = patternRoots(pkg, root[i+1:])
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("root %s not found in the matched packages", root)
+		}
+		nodes = append(nodes, found...)
+	}
+
+	if a.KeepGenerated {
+		for _, pkg := range pkgs {
+			nodes = append(nodes, generatedRoots(pkg)...)
+		}
+	}
+	r := a.collect(fset, pkgs, nodes, nil, nil)
+	var results []*Result
+	for _, pkg := range pkgs {
+		if decls := r.decls(fset, pkg.Syntax); len(decls) > 0 {
+			results = append(results, &Result{Used: r.used, Decls: decls, Graph: r.graph, Fset: fset, Pkg: pkg})
+		}
+	}
+	slog.Debug("Phase done", "phase", "traverse", "symbols", len(r.used), "packages", len(results), "duration", time.Since(start))
+	return results, nil
+}
+
+// leftImported reports whether pkg is kept as an import rather than cut,
+// because it falls under one of a.NoInline, is one of a.Exclude or, with
+// a.SkipGenerated, is made only of generated files.
+func (a *Analyzer) leftImported(pkg *packages.Package) bool {
+	path := pkg.PkgPath
+	if slices.Contains(a.Exclude, path) {
+		return true
+	}
+	if a.SkipGenerated && !slices.ContainsFunc(pkg.Syntax, func(f *ast.File) bool { return !ast.IsGenerated(f) }) {
+		return true
+	}
+	for _, prefix := range a.NoInline {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// patternRoots returns the top-level declarations of pkg declaring name.
+func patternRoots(pkg *packages.Package, name string) []ast.Node {
+	obj := pkg.Types.Scope().Lookup(name)
+	if obj == nil {
+		return nil
+	}
+	var nodes []ast.Node
+	for _, file := range pkg.Syntax {
+		nodes = append(nodes, rootNodes(file.Decls, func(n ast.Node) bool {
+			return nodeObject(n, pkg.TypesInfo) == obj
+		})...)
+	}
+	return nodes
+}
+
+// patternOutputDir returns the directory the cut of pkg is written to under
+// outDir, mirroring its location within its module.
+func patternOutputDir(outDir string, pkg *packages.Package) string {
+	rel := pkg.PkgPath
+	if pkg.Module != nil {
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, pkg.Module.Path), "/")
+	}
+	return filepath.Join(outDir, filepath.FromSlash(rel))
+}
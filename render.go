@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// generatedHeader marks output produced for golden testdata, following the
+// convention recognized by go vet and linters.
+const generatedHeader = "// Code generated by gocut; DO NOT EDIT.\n\n"
+
+// WriteOptions controls how the cut file is rendered.
+type WriteOptions struct {
+	// Generated prefixes the output with the "Code generated" header.
+	Generated bool
+	// LocalPrefix is the comma-separated list of import path prefixes
+	// goimports groups separately, like goimports -local.
+	LocalPrefix string
+	// PruneEmptyFiles skips mirrored files left without declarations.
+	PruneEmptyFiles bool
+	// PackageName, if set, replaces the package name of the output.
+	PackageName string
+	// KeepImports lists import paths the output imports whether or not the
+	// kept declarations refer to them, as blank imports unless they do.
+	KeepImports []string
+	// TabWidth is the width of a tab stop, 8 if zero.
+	TabWidth int
+	// UseSpaces indents with spaces, TabWidth of them per level, instead
+	// of tabs.
+	UseSpaces bool
+	// NormalizeWhitespace runs the output through gofmt until it no longer
+	// changes, so its whitespace is canonical however the declarations
+	// were assembled, before TabWidth and UseSpaces are applied.
+	NormalizeWhitespace bool
+	// TrimBlankLines collapses the runs of blank lines gofmt leaves, such
+	// as those of block comments, to a single one, outside of string
+	// literals.
+	TrimBlankLines bool
+	// OutputMode tells what to do with output files that exist already:
+	// "overwrite" them, the default, "skip" them, leaving them as they
+	// are, or fail with an "error".
+	OutputMode string
+	// ConsistentImports imports each package under the same name in all
+	// the files written for a package, where sources name it differently.
+	ConsistentImports bool
+}
+
+// printerConfig returns the printer configuration opts asks for, the one of
+// gofmt by default.
+func (opts WriteOptions) printerConfig() *printer.Config {
+	cfg := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if opts.TabWidth > 0 {
+		cfg.Tabwidth = opts.TabWidth
+	}
+	if opts.UseSpaces {
+		cfg.Mode = printer.UseSpaces
+	}
+	return cfg
+}
+
+// renderSource assembles the cut file of res: the header and package clause
+// of the entry file, the imports the kept declarations need, and the kept
+// declarations themselves. Comments attached to a kept declaration, or
+// floating directly above it (such as //go:generate directives), are
+// carried over.
+func renderSource(res *Result, opts WriteOptions) ([]byte, error) {
+	return renderFile(res, res.Entry, res.Decls, opts)
+}
+
+// renderFile assembles a file from the header and package clause of file
+// and decls, kept declarations of any file of the package.
+func renderFile(res *Result, file *ast.File, decls []ast.Decl, opts WriteOptions) ([]byte, error) {
+	fset := res.Fset
+	files := map[*token.File]*ast.File{}
+	for _, f := range res.Pkg.Syntax {
+		files[fset.File(f.Pos())] = f
+	}
+
+	var buf bytes.Buffer
+	if opts.Generated {
+		buf.WriteString(generatedHeader)
+	}
+	var header []*ast.CommentGroup
+	for _, cg := range file.Comments {
+		if cg.End() < file.Package {
+			header = append(header, cg)
+		}
+	}
+	writeComments(&buf, fset, header, file.Package)
+	name := file.Name.Name
+	if opts.PackageName != "" {
+		name = opts.PackageName
+	}
+	if name != "main" {
+		for _, decl := range decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "main" {
+				slog.Warn("Keeping func main in a package that is not main, it will not be a program entry point", "package", name)
+			}
+		}
+	}
+	buf.WriteString("package " + name + "\n")
+
+	if specs := importSpecs(res.Pkg.Types, res.Pkg.TypesInfo, file, decls, opts.KeepImports); len(specs) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, spec := range specs {
+			buf.WriteString("\t" + spec + "\n")
+		}
+		buf.WriteString(")\n")
+	}
+	for _, decl := range decls {
+		buf.WriteString("\n")
+		if err := printDecl(&buf, fset, files[fset.File(decl.Pos())], decl); err != nil {
+			return nil, err
+		}
+	}
+	src, err := opts.format(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return reindent(src, opts)
+}
+
+// maxFormatPasses bounds the gofmt passes made with NormalizeWhitespace.
+// Sources settle after two or three passes; the bound only guards against
+// a printer that never does.
+const maxFormatPasses = 10
+
+// format formats src, a Go file or a list of declarations, with gofmt, once
+// or, with opts.NormalizeWhitespace, until the result no longer changes: a
+// single pass may leave what it reformats on the next, such as the doc
+// comments of sources with CRLF line endings.
+func (opts WriteOptions) format(src []byte) ([]byte, error) {
+	for i := 1; ; i++ {
+		out, err := format.Source(src)
+		if err != nil || !opts.NormalizeWhitespace || bytes.Equal(out, src) || i == maxFormatPasses {
+			if err == nil && opts.TrimBlankLines {
+				out = trimBlankLines(out)
+			}
+			return out, err
+		}
+		src = out
+	}
+}
+
+// trimBlankLines collapses the runs of blank lines of src, a formatted Go
+// file or list of declarations, to a single blank line. The lines of string
+// literals are left alone.
+func trimBlankLines(src []byte) []byte {
+	var literals [][2]int
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.STRING {
+			start := file.Offset(pos)
+			literals = append(literals, [2]int{start, start + len(lit)})
+		}
+	}
+	inLiteral := func(off int) bool {
+		for _, l := range literals {
+			if off > l[0] && off < l[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out []byte
+	blank := false
+	for off := 0; off < len(src); {
+		end := bytes.IndexByte(src[off:], '\n') + 1
+		if end == 0 {
+			end = len(src) - off
+		}
+		line := src[off : off+end]
+		if len(bytes.TrimSpace(line)) > 0 || inLiteral(off) {
+			blank = false
+		} else if blank {
+			off += end
+			continue
+		} else {
+			blank = true
+		}
+		out = append(out, line...)
+		off += end
+	}
+	return out
+}
+
+// Fragments returns the kept declarations of res, each formatted on its own
+// with its comments, for use outside of a Go file: no package clause or
+// imports come with them.
+func Fragments(res *Result) ([]string, error) {
+	return FragmentsWithOptions(res, WriteOptions{})
+}
+
+// FragmentsWithOptions is like Fragments, formatting the declarations as
+// opts tells. Only opts.NormalizeWhitespace and opts.TrimBlankLines apply.
+func FragmentsWithOptions(res *Result, opts WriteOptions) ([]string, error) {
+	files := map[*token.File]*ast.File{}
+	for _, f := range res.Pkg.Syntax {
+		files[res.Fset.File(f.Pos())] = f
+	}
+	frags := make([]string, len(res.Decls))
+	for i, decl := range res.Decls {
+		var buf bytes.Buffer
+		if err := printDecl(&buf, res.Fset, files[res.Fset.File(decl.Pos())], decl); err != nil {
+			return nil, err
+		}
+		// A list of declarations is formatted as a partial file.
+		src, err := opts.format(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		frags[i] = string(src)
+	}
+	return frags, nil
+}
+
+// reindent reprints src, formatted by gofmt, with the tab width and
+// indentation of opts. Sources are returned as is for the defaults.
+func reindent(src []byte, opts WriteOptions) ([]byte, error) {
+	if opts.printerConfig().Tabwidth == 8 && !opts.UseSpaces {
+		return src, nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := opts.printerConfig().Fprint(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// printDecl prints decl, a top-level declaration of file or a copy of one
+// trimmed to some of its specs, together with its comments.
+func printDecl(buf *bytes.Buffer, fset *token.FileSet, file *ast.File, decl ast.Decl) error {
+	idx := 0
+	for i, d := range file.Decls {
+		if d.Pos() == decl.Pos() {
+			idx = i
+			break
+		}
+	}
+	orig := file.Decls[idx]
+	prevEnd := file.Name.End()
+	if idx > 0 {
+		prevEnd = file.Decls[idx-1].End()
+	}
+
+	// Comments of specs trimmed from a grouped declaration must not be
+	// printed at their old position.
+	var dropped [][2]token.Pos
+	if gd, ok := orig.(*ast.GenDecl); ok && orig != decl {
+		// Kept specs may be copies trimmed to some of their names.
+		keptPos := map[token.Pos]bool{}
+		for _, spec := range decl.(*ast.GenDecl).Specs {
+			for _, pos := range specKeys(spec) {
+				keptPos[pos] = true
+			}
+		}
+		kept := map[ast.Spec]bool{}
+		for _, spec := range gd.Specs {
+			for _, pos := range specKeys(spec) {
+				kept[spec] = kept[spec] || keptPos[pos]
+			}
+			if !kept[spec] {
+				start, end := specRange(spec)
+				dropped = append(dropped, [2]token.Pos{start, end})
+			}
+		}
+		dropped = append(dropped, orphanedComments(gd, kept, file.Comments)...)
+	}
+	// The comments of a function body replaced by a stub go with it.
+	if fn, ok := orig.(*ast.FuncDecl); ok && orig != decl && fn.Body != nil {
+		dropped = append(dropped, [2]token.Pos{fn.Body.Lbrace + 1, fn.Body.Rbrace})
+	}
+
+	start, end := declStart(decl), decl.End()
+	line := func(p token.Pos) int { return fset.PositionFor(p, false).Line }
+	var leading, inner []*ast.CommentGroup
+	for _, cg := range file.Comments {
+		switch {
+		case cg.Pos() > prevEnd && cg.End() < start && line(cg.Pos()) > line(prevEnd):
+			leading = append(leading, cg)
+		case cg.Pos() >= start && (cg.End() <= end || line(cg.Pos()) == line(end)):
+			inDropped := false
+			for _, r := range dropped {
+				if cg.Pos() >= r[0] && cg.End() <= r[1] {
+					inDropped = true
+				}
+			}
+			if !inDropped {
+				inner = append(inner, cg)
+			}
+		}
+	}
+
+	// Open a trimmed group right before its first kept spec or comment, so
+	// the lines of the dropped specs that preceded don't turn into blank
+	// lines.
+	if gd, ok := decl.(*ast.GenDecl); ok && orig != decl && gd.Lparen.IsValid() && len(gd.Specs) > 0 {
+		first, _ := specRange(gd.Specs[0])
+		for _, cg := range inner {
+			if cg.Pos() > gd.Lparen && cg.Pos() < first {
+				first = cg.Pos()
+			}
+		}
+		if line(first) > line(gd.Lparen)+1 {
+			trimmed := *gd
+			trimmed.Lparen = fset.File(first).LineStart(line(first)) - 1
+			decl = &trimmed
+		}
+	}
+
+	writeComments(buf, fset, leading, start)
+	if err := printer.Fprint(buf, fset, &printer.CommentedNode{Node: decl, Comments: inner}); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	return nil
+}
+
+// orphanedComments returns the extent of the comments floating among the
+// specs of the group d that only describe specs missing from kept. A comment
+// above the first spec describes the whole group. One further down heads the
+// specs up to the next floating comment, or belongs to the spec before it at
+// the end of the group.
+func orphanedComments(d *ast.GenDecl, kept map[ast.Spec]bool, comments []*ast.CommentGroup) [][2]token.Pos {
+	attached := map[*ast.CommentGroup]bool{}
+	for _, spec := range d.Specs {
+		switch s := spec.(type) {
+		case *ast.ValueSpec:
+			attached[s.Doc], attached[s.Comment] = true, true
+		case *ast.TypeSpec:
+			attached[s.Doc], attached[s.Comment] = true, true
+		}
+	}
+	// next returns the index of the first spec after pos.
+	next := func(pos token.Pos) int {
+		return sort.Search(len(d.Specs), func(i int) bool { return d.Specs[i].Pos() > pos })
+	}
+	var floating []*ast.CommentGroup
+	headed := map[int]bool{}
+	for _, cg := range comments {
+		if cg.Pos() > d.Lparen && cg.End() < d.Rparen && !attached[cg] {
+			floating = append(floating, cg)
+			headed[next(cg.Pos())] = true
+		}
+	}
+
+	var orphaned [][2]token.Pos
+	for _, cg := range floating {
+		i := next(cg.Pos())
+		if i == 0 {
+			continue
+		}
+		section := d.Specs[i-1 : i]
+		if i < len(d.Specs) {
+			j := i + 1
+			for j < len(d.Specs) && !headed[j] {
+				j++
+			}
+			section = d.Specs[i:j]
+		}
+		describesKept := false
+		for _, spec := range section {
+			describesKept = describesKept || kept[spec]
+		}
+		if !describesKept {
+			orphaned = append(orphaned, [2]token.Pos{cg.Pos(), cg.End()})
+		}
+	}
+	return orphaned
+}
+
+// writeComments writes the raw text of groups, which precede next, keeping a
+// blank line wherever the source had one.
+func writeComments(buf *bytes.Buffer, fset *token.FileSet, groups []*ast.CommentGroup, next token.Pos) {
+	for i, cg := range groups {
+		for _, c := range cg.List {
+			buf.WriteString(c.Text + "\n")
+		}
+		following := next
+		if i+1 < len(groups) {
+			following = groups[i+1].Pos()
+		}
+		if fset.PositionFor(following, false).Line-fset.PositionFor(cg.End(), false).Line > 1 {
+			buf.WriteString("\n")
+		}
+	}
+}
+
+// importSpecs returns the import specs the cut file needs, sorted by path:
+// every package referenced by decls, under the name it is referenced by or
+// as a dot import, plus the blank imports of file and blank imports of the
+// paths in keep. Imports are listed once, and blank ones only for
+// packages not otherwise imported. The list is complete on its own, so
+// goimports has nothing left to guess.
+func importSpecs(pkg *types.Package, info *types.Info, file *ast.File, decls []ast.Decl, keep []string) []string {
+	type spec struct{ name, path string }
+	seen := map[spec]bool{}
+	var specs []spec
+	add := func(name, path string) {
+		s := spec{name, path}
+		if !seen[s] {
+			seen[s] = true
+			specs = append(specs, s)
+		}
+	}
+
+	for _, imp := range file.Imports {
+		if imp.Name != nil && imp.Name.Name == "_" {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			add("_", path)
+		}
+	}
+	embeds := false
+	for _, decl := range decls {
+		if len(declEmbeds(decl)) > 0 {
+			embeds = true
+		}
+		qualified := map[*ast.Ident]bool{}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				qualified[n.Sel] = true
+			case *ast.Ident:
+				switch obj := info.Uses[n].(type) {
+				case *types.PkgName:
+					name := obj.Name()
+					if name == obj.Imported().Name() {
+						name = ""
+					}
+					add(name, obj.Imported().Path())
+				case nil:
+				default:
+					// An unqualified reference to a package-level object
+					// of another package resolves through a dot import.
+					if !qualified[n] && obj.Pkg() != nil && obj.Pkg() != pkg && obj.Parent() == obj.Pkg().Scope() {
+						add(".", obj.Pkg().Path())
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	// //go:embed needs the embed package imported, if only for its side
+	// effects when the variables are a string or []byte.
+	if embeds {
+		add("_", "embed")
+	}
+	for _, path := range keep {
+		add("_", path)
+	}
+
+	// A blank import is redundant next to another import of its package.
+	named := map[string]bool{}
+	for _, s := range specs {
+		if s.name != "_" {
+			named[s.path] = true
+		}
+	}
+	specs = slices.DeleteFunc(specs, func(s spec) bool { return s.name == "_" && named[s.path] })
+
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].path != specs[j].path {
+			return specs[i].path < specs[j].path
+		}
+		return specs[i].name < specs[j].name
+	})
+	lines := make([]string, len(specs))
+	for i, s := range specs {
+		lines[i] = strconv.Quote(s.path)
+		if s.name != "" {
+			lines[i] = s.name + " " + lines[i]
+		}
+	}
+	return lines
+}
+
+// unifyImportNames rewrites srcs, the files written for one package, so each
+// import path is imported under the same name in all of them: the name most
+// files use, the package's own name on a tie, as given by pkgNames. A file
+// keeps its name for a path where the other one is taken by another of its
+// identifiers.
+func unifyImportNames(srcs [][]byte, pkgNames map[string]string, opts WriteOptions) ([][]byte, error) {
+	fset := token.NewFileSet()
+	files := make([]*ast.File, len(srcs))
+	// names[i] maps the import paths of files[i] to their names, but for
+	// those it imports under several names, which are left alone.
+	names := make([]map[string]string, len(srcs))
+	counts := map[string]map[string]int{}
+	for i, src := range srcs {
+		file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files[i], names[i] = file, map[string]string{}
+		several := map[string]bool{}
+		for _, imp := range file.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := pkgNames[path]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name == "" || name == "_" || name == "." {
+				continue
+			}
+			if prev, ok := names[i][path]; ok && prev != name {
+				several[path] = true
+			}
+			names[i][path] = name
+		}
+		for path := range several {
+			delete(names[i], path)
+		}
+		for path, name := range names[i] {
+			if counts[path] == nil {
+				counts[path] = map[string]int{}
+			}
+			counts[path][name]++
+		}
+	}
+	chosen := map[string]string{}
+	for path, byName := range counts {
+		best := ""
+		for name, n := range byName {
+			switch {
+			case best == "" || n > byName[best]:
+				best = name
+			case n == byName[best] && best != pkgNames[path] && (name == pkgNames[path] || name < best):
+				best = name
+			}
+		}
+		chosen[path] = best
+	}
+
+	out := make([][]byte, len(srcs))
+	for i, file := range files {
+		renames := map[string]string{}
+		imported := map[string]bool{}
+		for _, name := range names[i] {
+			imported[name] = true
+		}
+		// taken holds the names of the file's identifiers other than the
+		// package qualifiers.
+		taken := map[string]bool{}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ImportSpec:
+				return false
+			case *ast.SelectorExpr:
+				if x, ok := n.X.(*ast.Ident); ok && x.Obj == nil && imported[x.Name] {
+					return false
+				}
+			case *ast.Ident:
+				taken[n.Name] = true
+			}
+			return true
+		})
+		for path, name := range names[i] {
+			if want := chosen[path]; want != name && !taken[want] && !imported[want] {
+				renames[name] = want
+			}
+		}
+		if len(renames) == 0 {
+			out[i] = srcs[i]
+			continue
+		}
+
+		for _, imp := range file.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name, ok := names[i][path]
+			if !ok || imp.Name != nil && (imp.Name.Name == "_" || imp.Name.Name == ".") {
+				continue
+			}
+			want, ok := renames[name]
+			if !ok {
+				continue
+			}
+			if want == pkgNames[path] {
+				imp.Name = nil
+			} else {
+				imp.Name = &ast.Ident{NamePos: imp.Path.Pos(), Name: want}
+			}
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				// An unresolved qualifier is not shadowed by a local.
+				if x, ok := sel.X.(*ast.Ident); ok && x.Obj == nil {
+					if want, ok := renames[x.Name]; ok {
+						x.Name = want
+					}
+				}
+			}
+			return true
+		})
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fset, file); err != nil {
+			return nil, err
+		}
+		var err error
+		if out[i], err = reindent(buf.Bytes(), opts); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
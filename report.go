@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Report is the machine-readable description of a cut.
+type Report struct {
+	Entry   string    `json:"entry"`
+	Output  string    `json:"output"`
+	Kept    []string  `json:"kept"`
+	Removed []Removed `json:"removed"`
+	Summary Summary   `json:"summary"`
+}
+
+// Removed describes a declaration the cut dropped.
+type Removed struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Summary describes how much smaller the cut is than its sources.
+type Summary struct {
+	OriginalBytes    int     `json:"original_bytes"`
+	OutputBytes      int     `json:"output_bytes"`
+	ReductionPercent float64 `json:"reduction_percent"`
+	DeclsKept        int     `json:"decls_kept"`
+	DeclsDropped     int     `json:"decls_dropped"`
+}
+
+// Summarize compares the source files of the analyzed package with output,
+// the content of the cut file.
+func Summarize(res *Result, output []byte) (Summary, error) {
+	s := Summary{OutputBytes: len(output)}
+	total := 0
+	for _, file := range res.Pkg.Syntax {
+		src, err := res.source(res.Fset.File(file.Pos()).Name())
+		if err != nil {
+			return Summary{}, err
+		}
+		s.OriginalBytes += len(src)
+		total += countDecls(file.Decls)
+	}
+	s.DeclsKept = countDecls(res.Decls)
+	s.DeclsDropped = total - s.DeclsKept
+	if s.OriginalBytes > 0 {
+		s.ReductionPercent = 100 * float64(s.OriginalBytes-s.OutputBytes) / float64(s.OriginalBytes)
+	}
+	return s, nil
+}
+
+// countDecls counts the functions and the specs of non-import declarations.
+func countDecls(decls []ast.Decl) int {
+	n := 0
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			n++
+		case *ast.GenDecl:
+			if d.Tok != token.IMPORT {
+				n += len(d.Specs)
+			}
+		}
+	}
+	return n
+}
+
+// declKinds counts the declarations counted by countDecls by kind: func,
+// method, type, var and const.
+func declKinds(decls []ast.Decl) map[string]int {
+	kinds := map[string]int{}
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil {
+				kinds["method"]++
+			} else {
+				kinds["func"]++
+			}
+		case *ast.GenDecl:
+			if d.Tok != token.IMPORT {
+				kinds[d.Tok.String()] += len(d.Specs)
+			}
+		}
+	}
+	return kinds
+}
+
+// RemovedDecls returns the top-level declarations of the analyzed package
+// missing from the cut, in source order, one per declared name.
+func RemovedDecls(res *Result) []Removed {
+	kept := map[token.Pos]bool{}
+	for _, decl := range res.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			kept[d.Pos()] = true
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				kept[spec.Pos()] = true
+				if vs, ok := spec.(*ast.ValueSpec); ok {
+					for _, name := range vs.Names {
+						kept[name.Pos()] = true
+					}
+				}
+			}
+		}
+	}
+
+	var removed []Removed
+	add := func(name, kind string, pos token.Pos) {
+		removed = append(removed, Removed{
+			Name: name,
+			Kind: kind,
+			File: res.Fset.File(pos).Name(),
+			Line: res.Fset.PositionFor(pos, false).Line,
+		})
+	}
+	for _, file := range res.Pkg.Syntax {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if kept[d.Pos()] {
+					continue
+				}
+				if d.Recv != nil {
+					add(recvTypeName(d)+"."+d.Name.Name, "method", d.Pos())
+				} else {
+					add(d.Name.Name, "func", d.Pos())
+				}
+			case *ast.GenDecl:
+				if d.Tok == token.IMPORT {
+					continue
+				}
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if !kept[s.Pos()] {
+							add(s.Name.Name, "type", s.Pos())
+						}
+					case *ast.ValueSpec:
+						// A kept spec may be trimmed to some of its names.
+						for _, name := range s.Names {
+							if !kept[name.Pos()] {
+								add(name.Name, d.Tok.String(), name.Pos())
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return removed
+}
+
+// WriteUsageCSV writes a row per object declared by the cut to w, as
+// symbol,kind,package,file,line,referenced_by_count, after a header. The
+// count is the number of objects whose declarations refer to the symbol.
+func WriteUsageCSV(w io.Writer, res *Result) error {
+	referrers := map[types.Object]int{}
+	for _, targets := range res.Graph.Edges {
+		for _, obj := range targets {
+			referrers[obj]++
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"symbol", "kind", "package", "file", "line", "referenced_by_count"})
+	for _, obj := range res.keptObjects() {
+		cw.Write([]string{
+			objectName(obj),
+			objectKind(obj),
+			obj.Pkg().Path(),
+			res.Fset.File(obj.Pos()).Name(),
+			strconv.Itoa(res.Fset.PositionFor(obj.Pos(), false).Line),
+			strconv.Itoa(referrers[obj]),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// objectKind names the kind of a package-level object as declKinds does.
+func objectKind(obj types.Object) string {
+	switch obj := obj.(type) {
+	case *types.Func:
+		if obj.Type().(*types.Signature).Recv() != nil {
+			return "method"
+		}
+		return "func"
+	case *types.TypeName:
+		return "type"
+	case *types.Const:
+		return "const"
+	}
+	return "var"
+}
+
+// declNames returns the sorted names declared by decls, with methods
+// qualified by their receiver type as "T.M".
+func declNames(decls []ast.Decl) []string {
+	var names []string
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil {
+				names = append(names, recvTypeName(d)+"."+d.Name.Name)
+			} else {
+				names = append(names, d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
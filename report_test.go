@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	output, err := renderSource(res, WriteOptions{})
+	if err != nil {
+		t.Fatalf("renderSource failed: %v", err)
+	}
+
+	summary, err := Summarize(res, output)
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	if summary.OutputBytes >= summary.OriginalBytes || summary.ReductionPercent <= 0 {
+		t.Errorf("expected a positive reduction, got %+v", summary)
+	}
+	// unused and Dropped are cut; Run, helper and Kept remain.
+	if summary.DeclsKept != 3 || summary.DeclsDropped != 2 {
+		t.Errorf("expected 3 kept and 2 dropped declarations, got %+v", summary)
+	}
+}
+
+func TestSummarizeOverlay(t *testing.T) {
+	dir, err := filepath.Abs(filepath.Join("test", "patch"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	disk, err := (&Analyzer{}).Analyze(filepath.Join(dir, "entry.go"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	src := []byte("package patch\n\n// Run returns Kept after greeting.\nfunc Run() string {\n\thelper()\n\treturn Kept\n}\n")
+	res, err := (&Analyzer{}).AnalyzeSource("entry.go", src, dir)
+	if err != nil {
+		t.Fatalf("AnalyzeSource failed: %v", err)
+	}
+
+	want, err := Summarize(disk, nil)
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	got, err := Summarize(res, nil)
+	if err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+	// The buffer replaces entry.go, which is shorter on disk.
+	size, err := os.Stat(filepath.Join(dir, "entry.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantBytes := want.OriginalBytes - int(size.Size()) + len(src); got.OriginalBytes != wantBytes {
+		t.Errorf("expected %d original bytes counting the buffer, got %d", wantBytes, got.OriginalBytes)
+	}
+}
+
+func TestDeclKinds(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// MainFunc, helper and MyStruct are reachable.
+	if n := countDecls(res.Decls); n != 3 {
+		t.Errorf("expected 3 reachable declarations, got %d", n)
+	}
+	kinds := declKinds(res.Decls)
+	if kinds["func"] != 2 || kinds["type"] != 1 || len(kinds) != 2 {
+		t.Errorf("expected 2 funcs and 1 type, got %v", kinds)
+	}
+}
+
+func TestRemovedDecls(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	other := filepath.Join(filepath.Dir(absEntry), "other.go")
+	want := []Removed{
+		{Name: "Dropped", Kind: "var", File: other, Line: 10},
+		{Name: "unused", Kind: "func", File: other, Line: 19},
+	}
+	if got := RemovedDecls(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected removed %+v, got %+v", want, got)
+	}
+}
+
+func TestWriteUsageCSV(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+	res, err := (&Analyzer{}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteUsageCSV(&buf, res); err != nil {
+		t.Fatalf("WriteUsageCSV failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if want := []string{"symbol", "kind", "package", "file", "line", "referenced_by_count"}; !reflect.DeepEqual(records[0], want) {
+		t.Errorf("expected header %v, got %v", want, records[0])
+	}
+	rows := map[string][]string{}
+	for _, record := range records[1:] {
+		rows[record[0]] = record
+	}
+	// helper is called from MainFunc only.
+	want := []string{"helper", "func", res.Pkg.PkgPath, absEntry, "9", "1"}
+	if !reflect.DeepEqual(rows["helper"], want) {
+		t.Errorf("expected row %v, got %v", want, rows["helper"])
+	}
+	if got := rows["MainFunc"]; got == nil || got[5] != "0" {
+		t.Errorf("expected MainFunc referenced by nothing, got %v", got)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LineRange selects the lines Start through End, inclusive, of File.
+type LineRange struct {
+	File       string
+	Start, End int
+}
+
+// ParseLineRange parses "file.go:40-80", or "file.go:40" for a single line.
+func ParseLineRange(s string) (*LineRange, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid line range %q, want file.go:start-end", s)
+	}
+	r := &LineRange{File: s[:i]}
+	start, end, found := strings.Cut(s[i+1:], "-")
+	var err error
+	if r.Start, err = strconv.Atoi(start); err != nil {
+		return nil, fmt.Errorf("invalid line range %q: %w", s, err)
+	}
+	r.End = r.Start
+	if found {
+		if r.End, err = strconv.Atoi(end); err != nil {
+			return nil, fmt.Errorf("invalid line range %q: %w", s, err)
+		}
+	}
+	if r.End < r.Start {
+		return nil, fmt.Errorf("invalid line range %q: end before start", s)
+	}
+	return r, nil
+}
+
+// roots returns the top-level functions and specs of the range's file that
+// overlap the range. path is the absolute path the file resolved to.
+func (r *LineRange) roots(fset *token.FileSet, files []*ast.File, path string) ([]ast.Node, error) {
+	for _, file := range files {
+		if fset.File(file.Pos()).Name() != path {
+			continue
+		}
+		return rootNodes(file.Decls, func(n ast.Node) bool {
+			return fset.PositionFor(n.Pos(), false).Line <= r.End && fset.PositionFor(n.End(), false).Line >= r.Start
+		}), nil
+	}
+	return nil, fmt.Errorf("%s is not part of the entry package", r.File)
+}
+
+// rootNodes returns the functions and non-import specs of decls accepted by
+// keep, or all of them if keep is nil.
+func rootNodes(decls []ast.Decl, keep func(ast.Node) bool) []ast.Node {
+	var nodes []ast.Node
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if keep == nil || keep(d) {
+				nodes = append(nodes, d)
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				if keep == nil || keep(spec) {
+					nodes = append(nodes, spec)
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// exportedNode reports whether n, a node returned by rootNodes, declares an
+// exported package-level function, type, variable or constant. Methods are
+// left to the types they belong to.
+func exportedNode(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		return n.Recv == nil && n.Name.IsExported()
+	case *ast.TypeSpec:
+		return n.Name.IsExported()
+	case *ast.ValueSpec:
+		for _, name := range n.Names {
+			if name.IsExported() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readInputList reads the entry files listed in the file at path, one per
+// line. Blank lines and lines starting with # are skipped, and relative
+// paths are resolved against the directory of the list.
+func readInputList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		entries = append(entries, line)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s lists no entry files", path)
+	}
+	return entries, nil
+}
+
+// nodeObject returns the object declared by a node returned by rootNodes.
+func nodeObject(node ast.Node, info *types.Info) types.Object {
+	if fn, ok := node.(*ast.FuncDecl); ok {
+		return info.Defs[fn.Name]
+	}
+	return specObject(node.(ast.Spec), info)
+}
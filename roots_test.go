@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want LineRange
+	}{
+		{"entry.go:40-80", LineRange{"entry.go", 40, 80}},
+		{"entry.go:7", LineRange{"entry.go", 7, 7}},
+		{"c:/src/entry.go:3-4", LineRange{"c:/src/entry.go", 3, 4}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLineRange(tt.in)
+		if err != nil {
+			t.Fatalf("ParseLineRange(%q) failed: %v", tt.in, err)
+		}
+		if *got != tt.want {
+			t.Errorf("ParseLineRange(%q) = %+v, want %+v", tt.in, *got, tt.want)
+		}
+	}
+	for _, bad := range []string{"entry.go", "entry.go:a-b", "entry.go:9-3"} {
+		if _, err := ParseLineRange(bad); err == nil {
+			t.Errorf("ParseLineRange(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestCollectKeepRange(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "ranges", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// Lines 3-5 hold First.
+	res, err := (&Analyzer{KeepRange: &LineRange{File: absEntry, Start: 3, End: 5}}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	names := declaredNames(res.Decls)
+	if len(names) != 2 || !names["First"] || !names["one"] {
+		t.Errorf("expected only First and one, got %v", names)
+	}
+}
+
+func TestAnalyzeInputList(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"go.mod":      "module example.com/list\n\ngo 1.23\n",
+		"first.go":    "package list\n\nfunc First() int {\n\treturn one()\n}\n",
+		"second.go":   "package list\n\nfunc Second() int {\n\treturn two()\n}\n",
+		"helpers.go":  "package list\n\nfunc one() int { return 1 }\n\nfunc two() int { return 2 }\n\nfunc three() int { return 3 }\n",
+		"entries.txt": "# entry files of the cut\nfirst.go\n\n  second.go  \n",
+	})
+
+	entries, err := readInputList(filepath.Join(root, "entries.txt"))
+	if err != nil {
+		t.Fatalf("readInputList failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1] != filepath.Join(root, "second.go") {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	res, err := (&Analyzer{}).AnalyzeFiles(entries...)
+	if err != nil {
+		t.Fatalf("AnalyzeFiles failed: %v", err)
+	}
+	names := declaredNames(res.Decls)
+	for _, sym := range []string{"First", "one", "Second", "two"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+	if names["three"] {
+		t.Errorf("expected unused helper three to be dropped")
+	}
+}
+
+func TestCollectRootFuncs(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "patch", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// unused is not referenced from the entry file, but keeps Dropped.
+	res, err := (&Analyzer{RootFuncs: []string{"unused"}}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := declaredNames(res.Decls)
+	for _, sym := range []string{"Run", "helper", "Kept", "unused", "Dropped"} {
+		if !names[sym] {
+			t.Errorf("expected decl for %s not found", sym)
+		}
+	}
+
+	if _, err := (&Analyzer{RootFuncs: []string{"Kept"}}).Analyze(absEntry); err == nil {
+		t.Errorf("expected an error for a root func naming a variable")
+	}
+}
+
+func TestCollectExportedRoots(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "sdk", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// Close is kept with Client though uncalled, url and the declarations
+	// of the entry file are not part of the API.
+	res, err := (&Analyzer{ExportedRoots: true}).Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if names := declNames(res.Decls); !slices.Equal(names, []string{"Client", "Client.Close", "NewClient", "release"}) {
+		t.Errorf("expected Client, Client.Close, NewClient and release, got %v", names)
+	}
+}
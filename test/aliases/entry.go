@@ -0,0 +1,7 @@
+package aliases
+
+import j "encoding/json"
+
+func Encode(v any) ([]byte, error) {
+	return j.Marshal(wrap(v))
+}
@@ -0,0 +1,15 @@
+package aliases
+
+import (
+	"encoding/json"
+	str "strings"
+)
+
+func wrap(v any) any {
+	return map[string]any{str.ToLower("Value"): v}
+}
+
+func raw(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
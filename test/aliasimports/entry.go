@@ -0,0 +1,7 @@
+package aliasimports
+
+import m "math"
+
+func Area(r float64) float64 {
+	return m.Pi * square(r)
+}
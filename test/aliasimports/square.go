@@ -0,0 +1,5 @@
+package aliasimports
+
+func square(x float64) float64 {
+	return x * x
+}
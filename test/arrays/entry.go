@@ -0,0 +1,5 @@
+package arrays
+
+func Weight(i int) int {
+	return weights[i]
+}
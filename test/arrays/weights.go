@@ -0,0 +1,10 @@
+package arrays
+
+const (
+	slots  = 5
+	first  = 2
+	last   = 4
+	unused = 3
+)
+
+var weights = [slots]int{first: 7, last: 9}
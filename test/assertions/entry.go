@@ -0,0 +1,5 @@
+package assertions
+
+func New() *Impl {
+	return &Impl{}
+}
@@ -0,0 +1,28 @@
+package assertions
+
+type Shape interface {
+	Area() float64
+	Label() string
+}
+
+type Impl struct{}
+
+var _ Shape = (*Impl)(nil)
+
+func (i *Impl) Area() float64 {
+	return 0
+}
+
+func (i *Impl) Label() string {
+	return "impl"
+}
+
+type Closer interface {
+	Close()
+}
+
+type other struct{}
+
+var _ Closer = other{}
+
+func (other) Close() {}
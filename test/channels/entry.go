@@ -0,0 +1,9 @@
+package channels
+
+func Drain(in <-chan *Job, out chan<- Result, done chan struct{ reason Reason }) {
+	for job := range in {
+		out <- Result{}
+		_ = job
+	}
+	close(done)
+}
@@ -0,0 +1,9 @@
+package channels
+
+type Job struct{}
+
+type Result struct{}
+
+type Reason string
+
+type Idle struct{}
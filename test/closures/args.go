@@ -0,0 +1,8 @@
+package closures
+
+import "os"
+
+// This init sets up a variable of another package only.
+func init() {
+	os.Args = append(os.Args, "-closures")
+}
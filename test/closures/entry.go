@@ -0,0 +1,7 @@
+package closures
+
+import "os"
+
+func Run() int {
+	return fib(len(os.Args))
+}
@@ -0,0 +1,22 @@
+package closures
+
+var fib func(int) int
+
+func init() {
+	fib = func(n int) int {
+		if n < 2 {
+			return base(n)
+		}
+		return fib(n-1) + fib(n-2)
+	}
+}
+
+func base(n int) int {
+	return n
+}
+
+var unrelated int
+
+func init() {
+	unrelated = 1
+}
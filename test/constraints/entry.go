@@ -0,0 +1,5 @@
+package constraints
+
+func Total() int {
+	return int(Sum([]int8{1, 2, 3}))
+}
@@ -0,0 +1,17 @@
+package constraints
+
+type MyInt int
+
+type MyFloat float64
+
+type Number interface {
+	~int8 | MyInt | MyFloat
+}
+
+func Sum[T Number](xs []T) T {
+	var total T
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
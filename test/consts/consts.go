@@ -0,0 +1,7 @@
+package consts
+
+const (
+	Alpha = "alpha"
+	Beta  = "beta"
+	Gamma = "gamma"
+)
@@ -0,0 +1,5 @@
+package consts
+
+func Greeting() string {
+	return Beta
+}
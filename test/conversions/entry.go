@@ -0,0 +1,15 @@
+package conversions
+
+// The converted types appear in no signature, only in the returns.
+
+func Names(s []string) any {
+	return names(s)
+}
+
+func Index(m map[string]int) any {
+	return (*lookup)(&m)
+}
+
+func Wrap(f func() int) any {
+	return []counter{counter(f)}
+}
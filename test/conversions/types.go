@@ -0,0 +1,9 @@
+package conversions
+
+type names []string
+
+type lookup map[string]int
+
+type counter func() int
+
+type unused []int
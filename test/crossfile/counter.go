@@ -0,0 +1,5 @@
+package crossfile
+
+type Counter struct {
+	n int
+}
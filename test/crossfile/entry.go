@@ -0,0 +1,7 @@
+package crossfile
+
+func Count() int {
+	var c Counter
+	c.Inc()
+	return c.n
+}
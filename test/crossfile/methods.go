@@ -0,0 +1,11 @@
+package crossfile
+
+func (c *Counter) Inc() {
+	c.n += step
+}
+
+func (c *Counter) Reset() {
+	c.n = 0
+}
+
+const step = 1
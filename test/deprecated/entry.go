@@ -0,0 +1,5 @@
+package deprecated
+
+func Run() int {
+	return oldSum(1, 2) + Limit
+}
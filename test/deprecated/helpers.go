@@ -0,0 +1,22 @@
+package deprecated
+
+// oldSum adds a and b.
+//
+// Deprecated: use sum instead.
+func oldSum(a, b int) int {
+	return sum(a, b)
+}
+
+func sum(a, b int) int {
+	return a + b
+}
+
+const (
+	// Limit bounds the sum.
+	//
+	// Deprecated: sums are unbounded now.
+	Limit = 10
+
+	// Deprecated: never used.
+	Other = 20
+)
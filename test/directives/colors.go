@@ -0,0 +1,11 @@
+package directives
+
+func unused() {}
+
+//go:generate stringer -type=Color
+
+// Color is a palette entry.
+type Color int
+
+// Red is the only color in the palette.
+const Red Color = 1
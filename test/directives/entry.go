@@ -0,0 +1,5 @@
+package directives
+
+func Favorite() Color {
+	return Red
+}
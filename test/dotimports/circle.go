@@ -0,0 +1,7 @@
+package dotimports
+
+import . "math"
+
+func circle(r float64) float64 {
+	return Pi * r * r
+}
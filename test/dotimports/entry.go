@@ -0,0 +1,7 @@
+package dotimports
+
+import . "strings"
+
+func Norm(x, y float64) string {
+	return TrimSpace(format(hypot(x, y)))
+}
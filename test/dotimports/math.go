@@ -0,0 +1,14 @@
+package dotimports
+
+import (
+	"fmt"
+	. "math"
+)
+
+func hypot(x, y float64) float64 {
+	return Sqrt(x*x + y*y)
+}
+
+func format(f float64) string {
+	return fmt.Sprint(f)
+}
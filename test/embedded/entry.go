@@ -0,0 +1,5 @@
+package embedded
+
+func Describe(s Source) string {
+	return s.Name()
+}
@@ -0,0 +1,17 @@
+package embedded
+
+import "io"
+
+// Source is a named stream.
+type Source interface {
+	io.Reader
+	Named
+}
+
+type Named interface {
+	Name() string
+}
+
+type Closer interface {
+	io.Closer
+}
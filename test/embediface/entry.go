@@ -0,0 +1,5 @@
+package embediface
+
+func Use(s Store) string {
+	return s.Get("key")
+}
@@ -0,0 +1,18 @@
+package embediface
+
+import "io"
+
+// Getter looks values up by key.
+type Getter interface {
+	Get(key string) string
+}
+
+// Store promotes the methods of its embedded interfaces.
+type Store struct {
+	Getter
+	io.Reader
+}
+
+type unused interface {
+	Put(key, value string)
+}
@@ -0,0 +1,3 @@
+package empty
+
+import _ "embed"
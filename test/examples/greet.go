@@ -0,0 +1,11 @@
+package examples
+
+import "fmt"
+
+func Greet() {
+	fmt.Println("hello")
+}
+
+func Farewell() {
+	fmt.Println("bye")
+}
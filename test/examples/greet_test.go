@@ -0,0 +1,9 @@
+package examples
+
+func ExampleGreet() {
+	Greet()
+}
+
+func ExampleFarewell() {
+	Farewell()
+}
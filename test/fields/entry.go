@@ -0,0 +1,5 @@
+package fields
+
+func New() *Server {
+	return &Server{}
+}
@@ -0,0 +1,22 @@
+package fields
+
+type Server struct {
+	Name  string
+	state serverState
+	*logger
+	queue []job
+}
+
+type serverState int
+
+type logger struct {
+	prefix string
+}
+
+type job struct {
+	id jobID
+}
+
+type jobID uint64
+
+type unrelated struct{}
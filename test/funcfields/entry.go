@@ -0,0 +1,7 @@
+package funcfields
+
+func NewConn() *Conn {
+	return &Conn{
+		hooks: Hooks{OnClose: cleanup, OnError: logError},
+	}
+}
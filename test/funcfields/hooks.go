@@ -0,0 +1,21 @@
+package funcfields
+
+type Conn struct {
+	hooks Hooks
+}
+
+type Hooks struct {
+	OnClose func()
+	OnError func(error)
+	OnOpen  func()
+}
+
+func cleanup() {
+	release()
+}
+
+func release() {}
+
+func logError(err error) {}
+
+func announce() {}
@@ -0,0 +1,5 @@
+package functypes
+
+func Run(h HandlerFunc) {
+	h(Response{}, &Request{})
+}
@@ -0,0 +1,23 @@
+package functypes
+
+type HandlerFunc func(Response, *Request)
+
+type Response struct {
+	Done Events
+}
+
+type Request struct {
+	Header Header
+}
+
+type Header map[Key][]Value
+
+type Key string
+
+type Value string
+
+type Events chan Event
+
+type Event struct{}
+
+type Unused struct{}
@@ -0,0 +1,5 @@
+package genassert
+
+func Run() any {
+	return Impl{}
+}
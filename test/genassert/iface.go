@@ -0,0 +1,34 @@
+package genassert
+
+type Getter[T any] interface {
+	Get() T
+}
+
+type Pair[K comparable, V any] interface {
+	Key() K
+	Val() V
+}
+
+type Impl struct{}
+
+var _ Getter[int] = Impl{}
+
+var _ Pair[string, Value] = (*Impl)(nil)
+
+func (Impl) Get() int {
+	return one
+}
+
+func (*Impl) Key() string {
+	return "key"
+}
+
+func (*Impl) Val() Value {
+	return Value{}
+}
+
+func (Impl) Unused() {}
+
+type Value struct{}
+
+const one = 1
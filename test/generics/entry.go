@@ -0,0 +1,5 @@
+package generics
+
+func Count() int {
+	return len(Fruits)
+}
@@ -0,0 +1,7 @@
+package generics
+
+type Set[T comparable] map[T]struct{}
+
+type Name string
+
+var Fruits = Set[Name]{"apple": {}, "pear": {}}
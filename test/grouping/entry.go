@@ -0,0 +1,13 @@
+package grouping
+
+import (
+	"fmt"
+	"go/ast"
+
+	"github.com/chenhg5/gocut/test/consts"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+func Run(f *ast.File) {
+	fmt.Println(consts.Beta, astutil.Imports(nil, f))
+}
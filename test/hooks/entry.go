@@ -0,0 +1,6 @@
+package hooks
+
+func Run() {
+	helper()
+	trace()
+}
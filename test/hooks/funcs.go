@@ -0,0 +1,13 @@
+package hooks
+
+func helper() {}
+
+func trace() {
+	helper()
+}
+
+func Extra() {
+	extraDep()
+}
+
+func extraDep() {}
@@ -0,0 +1,6 @@
+package ifacefields
+
+func Lookup(key string) string {
+	s := &Service{store: &memStore{}}
+	return s.store.Get(key)
+}
@@ -0,0 +1,21 @@
+package ifacefields
+
+type Store interface {
+	Get(key string) string
+}
+
+type Service struct {
+	store Store
+}
+
+type memStore struct {
+	data map[string]string
+}
+
+func (m *memStore) Get(key string) string {
+	return m.data[key]
+}
+
+func (m *memStore) Reset() {
+	clear(m.data)
+}
@@ -0,0 +1,14 @@
+package implicits
+
+func Area(s Shape) float64 {
+	switch v := s.(type) {
+	case *Circle:
+		return v.area()
+	case Rect:
+		return v.area()
+	case Polygon[Point]:
+		return float64(len(v.Points))
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,29 @@
+package implicits
+
+type Shape interface{}
+
+type Circle struct {
+	R float64
+}
+
+func (c *Circle) area() float64 {
+	return 3 * c.R * c.R
+}
+
+type Rect struct {
+	W, H float64
+}
+
+func (r Rect) area() float64 {
+	return r.W * r.H
+}
+
+type Polygon[P any] struct {
+	Points []P
+}
+
+type Point struct {
+	X, Y float64
+}
+
+type Triangle struct{}
@@ -0,0 +1,16 @@
+package inlineassert
+
+func Describe(v any) string {
+	if d, ok := v.(interface{ Describe() Label }); ok {
+		return string(d.Describe())
+	}
+	switch w := v.(type) {
+	case interface{ Weight() Grams }:
+		return w.Weight().String()
+	}
+	return ""
+}
+
+func Asserted() bool {
+	return ok
+}
@@ -0,0 +1,15 @@
+package inlineassert
+
+import "strconv"
+
+// Label is returned by the asserted method.
+type Label string
+
+// Grams is returned by the method asserted in the type switch.
+type Grams int
+
+func (g Grams) String() string {
+	return strconv.Itoa(int(g)) + "g"
+}
+
+type unused string
@@ -0,0 +1,9 @@
+package inlineassert
+
+var src any
+
+// The assertion in the initializer names Badge only through its method.
+var _, ok = src.(interface{ Describe() Badge })
+
+// Badge is returned by the method asserted at package level.
+type Badge string
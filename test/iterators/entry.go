@@ -0,0 +1,12 @@
+package iterators
+
+func Sum() int {
+	total := 0
+	for n := range Numbers(3) {
+		total += n
+	}
+	for k, v := range pairs {
+		total += k + v
+	}
+	return total
+}
@@ -0,0 +1,28 @@
+package iterators
+
+import "iter"
+
+// Numbers yields the integers below n.
+func Numbers(n int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := range n {
+			if !yield(start + i) {
+				return
+			}
+		}
+	}
+}
+
+const start = 0
+
+var pairs iter.Seq2[int, int] = func(yield func(int, int) bool) {
+	yield(1, weight())
+}
+
+func weight() int {
+	return 2
+}
+
+func unused() iter.Seq[int] {
+	return Numbers(1)
+}
@@ -0,0 +1,17 @@
+package methods
+
+type Client struct {
+	token string
+}
+
+var config = struct {
+	BaseURL string
+}{BaseURL: "https://example.com"}
+
+const apiPath = "/api"
+
+func (c *Client) URL() string {
+	return config.BaseURL + apiPath
+}
+
+var unusedConfig = "unused"
@@ -0,0 +1,5 @@
+package methods
+
+func Endpoint(c *Client) string {
+	return c.URL()
+}
@@ -0,0 +1,5 @@
+package methodsets
+
+func Smallest() Version {
+	return Min([]Version{{1}, {2}})
+}
@@ -0,0 +1,32 @@
+package methodsets
+
+type Ordered[T any] interface {
+	comparable
+	Less(other T, opts CompareOptions) bool
+}
+
+type CompareOptions struct {
+	Mode compareMode
+}
+
+type compareMode int
+
+func Min[T Ordered[T]](xs []T) T {
+	m := xs[0]
+	for _, x := range xs[1:] {
+		if x.Less(m, CompareOptions{}) {
+			m = x
+		}
+	}
+	return m
+}
+
+type Version struct {
+	Major int
+}
+
+func (v Version) Less(other Version, opts CompareOptions) bool {
+	return v.Major < other.Major
+}
+
+type Unrelated struct{}
@@ -0,0 +1,5 @@
+package multivars
+
+func Get() int {
+	return b + y
+}
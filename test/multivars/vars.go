@@ -0,0 +1,22 @@
+package multivars
+
+// a, b and c are set up together.
+var a, b, c = f(), g(), h()
+
+var (
+	// x and y are set up together too.
+	x, y = f(), h() // line comment
+	z    = g()
+)
+
+func f() int {
+	return 1
+}
+
+func g() int {
+	return 2
+}
+
+func h() int {
+	return 3
+}
@@ -0,0 +1,13 @@
+package namedresults
+
+func Split(s string) (head, tail Part, err error) {
+	return
+}
+
+func Bounds() (lo, hi Limit) {
+	return
+}
+
+var Parse = func() (p Pair, err error) {
+	return
+}
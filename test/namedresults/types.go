@@ -0,0 +1,11 @@
+package namedresults
+
+type Part string
+
+type Limit int
+
+type Pair struct {
+	Left, Right Part
+}
+
+type unused struct{}
@@ -0,0 +1,28 @@
+package nested
+
+// Config is decoded from the `config` tags of its fields.
+type Config struct {
+	Server Server `config:"server"`
+}
+
+type Server struct {
+	Name string `config:"name"`
+	TLS  TLS    `config:"tls"`
+}
+
+type TLS struct {
+	Certs []Cert         `config:"certs"`
+	Modes map[Mode]Level `config:"modes"`
+}
+
+type Cert struct {
+	Path string `config:"path"`
+}
+
+type Mode string
+
+type Level int
+
+type unused struct {
+	Cert Cert
+}
@@ -0,0 +1,5 @@
+package nested
+
+func Load(c *Config) string {
+	return c.Server.Name
+}
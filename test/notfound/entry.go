@@ -0,0 +1,5 @@
+package notfound
+
+func Find() error {
+	return &NotFound{}
+}
@@ -0,0 +1,8 @@
+package notfound
+
+// NotFound is only used as an error.
+type NotFound struct{}
+
+func (*NotFound) Error() string {
+	return "not found"
+}
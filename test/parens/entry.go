@@ -0,0 +1,5 @@
+package parens
+
+func Run() any {
+	return newLocal()
+}
@@ -0,0 +1,13 @@
+package parens
+
+type local struct {
+	inner (inner)
+}
+
+type inner int
+
+type unused int
+
+func newLocal() *(local) {
+	return nil
+}
@@ -0,0 +1,6 @@
+package patch
+
+func Run() string {
+	helper()
+	return Kept
+}
@@ -0,0 +1,21 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	Kept    = "kept"
+	Dropped = "dropped"
+)
+
+// helper prints a greeting.
+func helper() {
+	fmt.Println("hello")
+}
+
+// unused is never called.
+func unused() string {
+	return strings.ToUpper(Dropped)
+}
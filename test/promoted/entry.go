@@ -0,0 +1,5 @@
+package promoted
+
+func Label(r *Request) string {
+	return r.Method + " " + r.Path
+}
@@ -0,0 +1,19 @@
+package promoted
+
+// Request embeds the fields it shares with responses.
+type Request struct {
+	header
+	*route
+}
+
+type header struct {
+	Method string
+}
+
+type route struct {
+	Path string
+}
+
+type unused struct {
+	Method string
+}
@@ -0,0 +1,9 @@
+package ranges
+
+func First() int {
+	return one()
+}
+
+func Second() int {
+	return two()
+}
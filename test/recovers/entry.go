@@ -0,0 +1,5 @@
+package recovers
+
+func Run() {
+	Guarded()
+}
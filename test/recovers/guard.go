@@ -0,0 +1,25 @@
+package recovers
+
+import "fmt"
+
+type PanicError struct {
+	Reason string
+}
+
+func (e *PanicError) Error() string {
+	return e.Reason
+}
+
+var Guarded = func() {
+	defer func() {
+		if e := recover(); e != nil {
+			switch err := e.(type) {
+			case *PanicError:
+				fmt.Println("recovered:", err.Reason)
+			default:
+				panic(e)
+			}
+		}
+	}()
+	panic(&PanicError{Reason: "boom"})
+}
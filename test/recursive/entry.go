@@ -0,0 +1,9 @@
+package recursive
+
+func Walk(n *Node, t *Tree) int {
+	count := 0
+	for ; n != nil; n = n.Next {
+		count++
+	}
+	return count + len(t.Root.Children)
+}
@@ -0,0 +1,22 @@
+package recursive
+
+// Node is a linked list node.
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+// Tree and Branch refer to each other.
+type Tree struct {
+	Root *Branch
+}
+
+type Branch struct {
+	Tree     *Tree
+	Children []Branch
+	Index    map[string]*Branch
+}
+
+type orphan struct {
+	next *orphan
+}
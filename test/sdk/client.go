@@ -0,0 +1,24 @@
+package sdk
+
+// Client talks to the service.
+type Client struct {
+	base string
+}
+
+// NewClient returns a client of the service at base.
+func NewClient(base string) *Client {
+	return &Client{base: base}
+}
+
+// Close is part of the API, though nothing in the package calls it.
+func (c *Client) Close() error {
+	return release(c.base)
+}
+
+func (c *Client) url() string {
+	return c.base
+}
+
+func release(string) error {
+	return nil
+}
@@ -0,0 +1,7 @@
+package sdk
+
+func debug() string {
+	return "debug " + version
+}
+
+const version = "1.0"
@@ -0,0 +1,5 @@
+package selectors
+
+func Lookup(s *Service) string {
+	return s.Store.Get()
+}
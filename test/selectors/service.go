@@ -0,0 +1,15 @@
+package selectors
+
+type Service struct {
+	Store *Store
+}
+
+type Store struct {
+	prefix string
+}
+
+func (s *Store) Get() string {
+	return s.prefix + suffix
+}
+
+var suffix = "!"
@@ -0,0 +1,12 @@
+package sentinels
+
+func Find(items map[string]int, key string) (int, error) {
+	v, ok := items[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if v < 0 {
+		panic(msgNegative)
+	}
+	return v, nil
+}
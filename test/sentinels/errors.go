@@ -0,0 +1,14 @@
+package sentinels
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned for missing keys.
+var ErrNotFound = errors.New("not found")
+
+// ErrClosed is never returned.
+var ErrClosed = fmt.Errorf("closed")
+
+const msgNegative = "negative value"
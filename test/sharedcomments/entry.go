@@ -0,0 +1,5 @@
+package sharedcomments
+
+func Run() int {
+	return retries + limit + ratio
+}
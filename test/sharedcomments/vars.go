@@ -0,0 +1,29 @@
+package sharedcomments
+
+// Defaults shared by every client.
+var (
+	timeout = 30
+	retries = 3 // retries per request
+	backoff = 2
+)
+
+var (
+	// Limits of the pool, in connections.
+
+	minConns = 1
+	limit    = 8
+)
+
+var (
+	scale = 1
+
+	// Ratios nobody uses.
+
+	low  = 1
+	high = 9
+
+	// Ratios of the cache.
+
+	ratio = 2
+	// more ratios to come
+)
@@ -0,0 +1,17 @@
+package stubs
+
+type Address string
+
+type Options struct {
+	Timeout int
+}
+
+type Conn struct{}
+
+func dial(addr Address) *Conn {
+	return &Conn{}
+}
+
+func handshake(c *Conn) error {
+	return nil
+}
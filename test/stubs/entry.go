@@ -0,0 +1,8 @@
+package stubs
+
+// Open connects to addr.
+func Open(addr Address, opts *Options) (*Conn, error) {
+	// Dial first, then handshake.
+	c := dial(addr)
+	return c, handshake(c)
+}
@@ -0,0 +1,13 @@
+package switches
+
+import "net/http"
+
+func Describe(code int) string {
+	switch code {
+	case StatusOK, http.StatusCreated:
+		return okText
+	case statusMissing:
+		return missingText()
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package switches
+
+const (
+	StatusOK      = 200
+	statusMissing = 404
+	statusTeapot  = 418
+)
+
+const okText = "ok"
+
+func missingText() string {
+	return "missing"
+}
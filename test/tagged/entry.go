@@ -0,0 +1,5 @@
+package tagged
+
+func Load() *User {
+	return &User{}
+}
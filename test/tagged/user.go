@@ -0,0 +1,47 @@
+package tagged
+
+import "fmt"
+
+// User is filled in by a database mapper matching the db tags.
+type User struct {
+	ID    ID      `db:"id"`
+	Email Email   `db:"email"`
+	Roles []*Role `db:"roles"`
+	note  string
+}
+
+// TableName is looked up by the mapper through reflection.
+func (u *User) TableName() string {
+	return "users"
+}
+
+type ID int64
+
+// Scan is called by the database driver.
+func (id *ID) Scan(src any) error {
+	n, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("unexpected id %v", src)
+	}
+	*id = ID(n)
+	return nil
+}
+
+type Email string
+
+func (e Email) Value() (any, error) {
+	return string(e), nil
+}
+
+type Role struct {
+	Name string
+}
+
+func (r *Role) Scan(src any) error {
+	r.Name = fmt.Sprint(src)
+	return nil
+}
+
+type Unused struct {
+	ID ID `db:"id"`
+}
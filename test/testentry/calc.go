@@ -0,0 +1,13 @@
+package testentry
+
+func Add(a, b int) int {
+	return add(a, b)
+}
+
+func add(a, b int) int {
+	return a + b
+}
+
+func Sub(a, b int) int {
+	return a - b
+}
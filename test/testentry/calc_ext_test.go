@@ -0,0 +1,13 @@
+package testentry_test
+
+import (
+	"testing"
+
+	"github.com/chenhg5/gocut/test/testentry"
+)
+
+func TestSub(t *testing.T) {
+	if testentry.Sub(3, 2) != 1 {
+		t.Error("expected 3 - 2 to be 1")
+	}
+}
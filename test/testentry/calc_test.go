@@ -0,0 +1,9 @@
+package testentry
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Error("expected 1 + 2 to be 3")
+	}
+}
@@ -0,0 +1,5 @@
+package testmain
+
+func Double(n int) int {
+	return 2 * n
+}
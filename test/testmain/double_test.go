@@ -0,0 +1,9 @@
+package testmain
+
+import "testing"
+
+func TestDouble(t *testing.T) {
+	if Double(2) != 4 {
+		t.Error("expected 2 doubled to be 4")
+	}
+}
@@ -0,0 +1,11 @@
+package testmain
+
+var ready bool
+
+func setup() {
+	ready = true
+}
+
+func teardown() {
+	ready = false
+}
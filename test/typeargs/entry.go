@@ -0,0 +1,5 @@
+package typeargs
+
+func Sizes() (int, int) {
+	return len(rows), len(index)
+}
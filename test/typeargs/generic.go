@@ -0,0 +1,21 @@
+package typeargs
+
+func Make[T any](n int) []T {
+	return make([]T, n)
+}
+
+func MakeMap[K comparable, V any]() map[K]V {
+	return map[K]V{}
+}
+
+type Row struct{}
+
+type Key string
+
+type Val struct{}
+
+type Unused struct{}
+
+var rows = Make[[]Row](3)
+
+var index = MakeMap[Key, *Val]()
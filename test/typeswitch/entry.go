@@ -0,0 +1,5 @@
+package typeswitch
+
+func Describe(v any) string {
+	return kind(v)
+}
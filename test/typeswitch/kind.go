@@ -0,0 +1,20 @@
+package typeswitch
+
+type Circle struct {
+	Radius float64
+}
+
+type Square struct {
+	Side float64
+}
+
+func kind(v any) string {
+	switch v.(type) {
+	case *Circle:
+		return "circle"
+	case []Square:
+		return "squares"
+	default:
+		return "unknown"
+	}
+}
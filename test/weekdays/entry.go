@@ -0,0 +1,5 @@
+package weekdays
+
+func Weekend(d Weekday) bool {
+	return d == Saturday || d == Sunday
+}
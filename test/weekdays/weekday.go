@@ -0,0 +1,24 @@
+package weekdays
+
+// Weekday is a day of the week.
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+	Wednesday
+	Thursday
+	Friday
+	Saturday
+)
+
+// Unit is a unit of storage size.
+type Unit int64
+
+const (
+	_       = iota
+	KB Unit = 1 << (10 * iota)
+	MB
+	GB
+)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// importerFunc implements types.Importer with a function.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// Verify type-checks files, the cut of res keyed by file name as written by
+// WriteMirroredSourceTo, against the packages imported by the analyzed
+// package. It returns the errors of the cut, as "file:line:col: message"
+// sorted by position. They point at declarations the traversal failed to
+// keep: references left undefined, but also methods dropped although an
+// interface needs them.
+func Verify(res *Result, files map[string]*bytes.Buffer) ([]string, error) {
+	imported := map[string]*types.Package{"unsafe": types.Unsafe}
+	for _, pkg := range res.Pkg.Types.Imports() {
+		imported[pkg.Path()] = pkg
+	}
+	importer := importerFunc(func(path string) (*types.Package, error) {
+		if pkg, ok := imported[path]; ok {
+			return pkg, nil
+		}
+		return nil, fmt.Errorf("package %s is not imported by %s", path, res.Pkg.PkgPath)
+	})
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, name := range names {
+		file, err := parser.ParseFile(fset, name, files[name].Bytes(), 0)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, file)
+	}
+
+	var missing []types.Error
+	cfg := &types.Config{
+		Importer: importer,
+		Error: func(err error) {
+			if terr, ok := err.(types.Error); ok {
+				missing = append(missing, terr)
+			}
+		},
+	}
+	// The errors are collected by cfg.Error.
+	cfg.Check(res.Pkg.PkgPath, fset, parsed, nil)
+
+	sort.SliceStable(missing, func(i, j int) bool { return missing[i].Pos < missing[j].Pos })
+	result := make([]string, len(missing))
+	for i, err := range missing {
+		result[i] = err.Error()
+	}
+	return result, nil
+}
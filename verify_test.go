@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"go/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	for _, gap := range []bool{false, true} {
+		analyzer := &Analyzer{}
+		if gap {
+			// Stand in for a traversal gap by dropping a needed function.
+			analyzer.KeepFunc = func(obj types.Object) (bool, bool) {
+				return false, obj.Name() == "helper"
+			}
+		}
+		res, err := analyzer.Analyze(absEntry)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		files := map[string]*bytes.Buffer{}
+		if err := WriteMirroredSourceTo(files, res, WriteOptions{}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+
+		missing, err := Verify(res, files)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if !gap && len(missing) != 0 {
+			t.Errorf("expected a complete cut, got %v", missing)
+		}
+		if gap && (len(missing) != 1 || !strings.HasPrefix(missing[0], "entry.go:") || !strings.HasSuffix(missing[0], "undefined: helper")) {
+			t.Errorf("expected helper to be reported undefined, got %v", missing)
+		}
+	}
+}
+
+func TestVerifyMissingMethod(t *testing.T) {
+	absEntry, err := filepath.Abs(filepath.Join("test", "notfound", "entry.go"))
+	if err != nil {
+		t.Fatal("failed to get absolute path:", err)
+	}
+
+	// Drop the method NotFound needs to be an error, which nothing calls by
+	// name.
+	analyzer := &Analyzer{KeepFunc: func(obj types.Object) (bool, bool) {
+		return false, obj.Name() == "Error"
+	}}
+	res, err := analyzer.Analyze(absEntry)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	files := map[string]*bytes.Buffer{}
+	if err := WriteMirroredSourceTo(files, res, WriteOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	missing, err := Verify(res, files)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if len(missing) != 1 || !strings.HasPrefix(missing[0], "entry.go:") || !strings.Contains(missing[0], "missing method Error") {
+		t.Errorf("expected the missing Error method to be reported, got %v", missing)
+	}
+}